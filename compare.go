@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// loadResults reads results previously written with -output in json or
+// jsonl format
+func loadResults(path string) (results []Result, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	switch format := outputFormatFor(path, ""); format {
+	case "json":
+		err = json.NewDecoder(f).Decode(&results)
+	case "jsonl":
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			var r Result
+			if err = json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				return
+			}
+			results = append(results, r)
+		}
+		err = scanner.Err()
+	default:
+		err = fmt.Errorf("cannot load baseline %s: format %q is not json or jsonl", path, format)
+	}
+	return
+}
+
+// latency returns the latency histogram of all of g's iterations merged
+func (g *resultGroup) latency() *Histogram {
+	h := NewHistogram()
+	for _, r := range g.Results {
+		h.Merge(r.Latency)
+	}
+	return h
+}
+
+// comparison holds the baseline and current measurements of one scenario phase
+type comparison struct {
+	Scenario  string
+	Phase     string
+	Baseline  bool
+	BaseOps   float64
+	CurOps    float64
+	BaseP99   time.Duration
+	CurP99    time.Duration
+	Regressed []string
+}
+
+// OpsDelta returns the change in throughput relative to the baseline in percent
+func (c comparison) OpsDelta() float64 {
+	return percentChange(c.BaseOps, c.CurOps)
+}
+
+// P99Delta returns the change in p99 latency relative to the baseline in percent
+func (c comparison) P99Delta() float64 {
+	return percentChange(float64(c.BaseP99), float64(c.CurP99))
+}
+
+// percentChange returns the change from base to cur in percent of base
+func percentChange(base, cur float64) float64 {
+	if base == 0 {
+		return 0
+	}
+	return (cur - base) / base * 100
+}
+
+// compareResults compares the mean throughput and p99 latency of every phase
+// in current with those in baseline.  A phase regresses when its throughput
+// drops by more than maxOpsDrop percent or its p99 latency grows by more than
+// maxP99Rise percent
+func compareResults(baseline, current []Result, maxOpsDrop, maxP99Rise float64) (comps []comparison) {
+	base := map[[3]string]*resultGroup{}
+	for _, g := range groupResults(baseline) {
+		base[g.key()] = g
+	}
+
+	for _, g := range groupResults(current) {
+		c := comparison{
+			Scenario: g.Scenario,
+			Phase:    g.Phase,
+			CurOps:   g.opsPerSecStats().Mean,
+			CurP99:   g.latency().Percentile(99),
+		}
+		if b, ok := base[g.key()]; ok {
+			c.Baseline = true
+			c.BaseOps = b.opsPerSecStats().Mean
+			c.BaseP99 = b.latency().Percentile(99)
+			if -c.OpsDelta() > maxOpsDrop {
+				c.Regressed = append(c.Regressed, "throughput")
+			}
+			if c.P99Delta() > maxP99Rise {
+				c.Regressed = append(c.Regressed, "p99")
+			}
+		}
+		comps = append(comps, c)
+	}
+	return
+}
+
+// configDifferences lists the workload settings in which a and b differ.
+// Driver and SQLite versions are deliberately left out as comparing them is
+// what the compare mode is for
+func configDifferences(a, b RunConfig) (diffs []string) {
+	if a.DSNOptions != b.DSNOptions {
+		diffs = append(diffs, fmt.Sprintf("dsn_options %q != %q", a.DSNOptions, b.DSNOptions))
+	}
+	if formatPragmas(a.Pragmas) != formatPragmas(b.Pragmas) {
+		diffs = append(diffs, fmt.Sprintf("pragmas %q != %q", formatPragmas(a.Pragmas), formatPragmas(b.Pragmas)))
+	}
+	if a.RowCount != b.RowCount {
+		diffs = append(diffs, fmt.Sprintf("row_count %d != %d", a.RowCount, b.RowCount))
+	}
+	if a.UpdateCount != b.UpdateCount {
+		diffs = append(diffs, fmt.Sprintf("update_count %d != %d", a.UpdateCount, b.UpdateCount))
+	}
+	if a.BatchSize != b.BatchSize {
+		diffs = append(diffs, fmt.Sprintf("batch_size %d != %d", a.BatchSize, b.BatchSize))
+	}
+	if a.CommitEvery != b.CommitEvery {
+		diffs = append(diffs, fmt.Sprintf("commit_every %d != %d", a.CommitEvery, b.CommitEvery))
+	}
+	if a.Workers != b.Workers {
+		diffs = append(diffs, fmt.Sprintf("workers %d != %d", a.Workers, b.Workers))
+	}
+	if a.MaxOpenConns != b.MaxOpenConns {
+		diffs = append(diffs, fmt.Sprintf("max_open_conns %d != %d", a.MaxOpenConns, b.MaxOpenConns))
+	}
+	if a.SplitPools != b.SplitPools || a.ReaderConns != b.ReaderConns {
+		diffs = append(diffs, fmt.Sprintf("split_pools/reader_conns %t/%d != %t/%d", a.SplitPools, a.ReaderConns,
+			b.SplitPools, b.ReaderConns))
+	}
+	if a.Mixed != b.Mixed {
+		diffs = append(diffs, fmt.Sprintf("mixed %t != %t", a.Mixed, b.Mixed))
+	}
+	if a.Workload != b.Workload || a.WorkloadOps != b.WorkloadOps || a.WorkloadTime != b.WorkloadTime {
+		diffs = append(diffs, fmt.Sprintf("workload %q/%d/%s != %q/%d/%s", a.Workload, a.WorkloadOps, a.WorkloadTime,
+			b.Workload, b.WorkloadOps, b.WorkloadTime))
+	}
+	if a.Order != b.Order {
+		diffs = append(diffs, fmt.Sprintf("order %s != %s", a.Order, b.Order))
+	}
+	if a.KeyDist != b.KeyDist || a.Skew != b.Skew {
+		diffs = append(diffs, fmt.Sprintf("key_dist/skew %s/%g != %s/%g", a.KeyDist, a.Skew, b.KeyDist, b.Skew))
+	}
+	if a.KeyDist == keysHotspot && b.KeyDist == keysHotspot && (a.HotSet != b.HotSet || a.HotOps != b.HotOps) {
+		diffs = append(diffs, fmt.Sprintf("hot_set/hot_ops %g/%g != %g/%g", a.HotSet, a.HotOps, b.HotSet, b.HotOps))
+	}
+	if a.GroupSize != b.GroupSize || a.GroupWait != b.GroupWait {
+		diffs = append(diffs, fmt.Sprintf("group_size/group_wait %d/%s != %d/%s", a.GroupSize, a.GroupWait, b.GroupSize,
+			b.GroupWait))
+	}
+	if a.UseTransaction != b.UseTransaction {
+		diffs = append(diffs, fmt.Sprintf("use_transaction %t != %t", a.UseTransaction, b.UseTransaction))
+	}
+	return
+}
+
+// printComparison writes a table of per-phase deltas to w
+func printComparison(w io.Writer, comps []comparison) {
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Scenario\tPhase\tBase ops/sec\tOps/sec\tDelta\tBase p99\tp99\tDelta\tStatus\t")
+	for _, c := range comps {
+		if !c.Baseline {
+			fmt.Fprintf(tw, "%s\t%s\t-\t%.0f\t-\t-\t%s\t-\tnew\t\n", c.Scenario, c.Phase, c.CurOps,
+				roundLatency(c.CurP99))
+			continue
+		}
+		status := "ok"
+		if len(c.Regressed) > 0 {
+			status = "REGRESSED " + strings.Join(c.Regressed, ",")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.0f\t%.0f\t%+.1f%%\t%s\t%s\t%+.1f%%\t%s\t\n", c.Scenario, c.Phase, c.BaseOps,
+			c.CurOps, c.OpsDelta(), roundLatency(c.BaseP99), roundLatency(c.CurP99), c.P99Delta(), status)
+	}
+	tw.Flush()
+}
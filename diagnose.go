@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lbe/go-sql-test/gen/model"
+)
+
+// versions of trg_user_update installed by the update diagnostics
+const (
+	triggerSchema = "schema" // as created by dbCreateSchema
+	triggerNone   = "none"   // dropped
+	triggerRow    = "row"    // rowTriggerDDL
+)
+
+var triggerVariants = []string{triggerSchema, triggerNone, triggerRow}
+
+// rowTriggerDDL is trg_user_update restricted to the updated row and to the
+// columns of the table
+const rowTriggerDDL = `
+	CREATE TRIGGER trg_user_update AFTER UPDATE
+		OF user, city, region, country, area_code, zip_code, year_birth, im, name
+		ON user
+	BEGIN
+	UPDATE user
+		SET changed_tst = STRFTIME('%F %T','now','localtime')
+	WHERE user = new.user;
+	END;`
+
+var (
+	triggerOfRe   = regexp.MustCompile(`(?is)\bUPDATE\s+OF\s+(.*?)\s+ON\s`)
+	triggerBodyRe = regexp.MustCompile(`(?is)\bBEGIN\s+(.*?);?\s*END\s*;?\s*$`)
+	triggerRefRe  = regexp.MustCompile(`(?i)\b(old|new)\.\w+`)
+)
+
+// installTrigger replaces trg_user_update with variant
+func installTrigger(variant string) (err error) {
+	if _, err = opt.db.Exec(`DROP TRIGGER IF EXISTS trg_user_update;`); err != nil {
+		return
+	}
+	switch variant {
+	case triggerSchema:
+		err = dbCreateSchema()
+	case triggerRow:
+		_, err = opt.db.Exec(rowTriggerDDL)
+	}
+	return
+}
+
+// triggerReport describes the trg_user_update installed for one variant
+type triggerReport struct {
+	Variant string
+	DDL     string
+	Body    string
+	Plan    []string
+	Missing []string
+	Written int64
+}
+
+// inspectTrigger reads trg_user_update back from sqlite_master, lists the
+// columns of its UPDATE OF clause that are not in the user table and explains
+// its body, with the old. and new. references bound as parameters
+func inspectTrigger(db *sql.DB, variant string) (r triggerReport, err error) {
+	r.Variant = variant
+	err = db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'trigger' AND name = 'trg_user_update';`).
+		Scan(&r.DDL)
+	if err == sql.ErrNoRows {
+		return r, nil
+	}
+	if err != nil {
+		return
+	}
+
+	columns, err := tableColumns(db, "user")
+	if err != nil {
+		return
+	}
+	if m := triggerOfRe.FindStringSubmatch(r.DDL); m != nil {
+		for _, c := range strings.Split(m[1], ",") {
+			if c = strings.TrimSpace(c); !columns[strings.ToLower(c)] {
+				r.Missing = append(r.Missing, c)
+			}
+		}
+	}
+
+	m := triggerBodyRe.FindStringSubmatch(r.DDL)
+	if m == nil {
+		return r, fmt.Errorf("cannot find the body of trg_user_update in %q", r.DDL)
+	}
+	r.Body = strings.Join(strings.Fields(m[1]), " ")
+	query := triggerRefRe.ReplaceAllString(r.Body, "?")
+	args := make([]interface{}, len(triggerRefRe.FindAllString(r.Body, -1)))
+	for i := range args {
+		args[i] = ""
+	}
+	r.Plan, err = explainQueryPlan(db, query, args...)
+	return
+}
+
+// tableColumns returns the set of lower case column names of table
+func tableColumns(db *sql.DB, table string) (columns map[string]bool, err error) {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?);`, table)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	columns = map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return
+		}
+		columns[strings.ToLower(name)] = true
+	}
+	return columns, rows.Err()
+}
+
+// explainQueryPlan returns the detail lines of EXPLAIN QUERY PLAN for query
+func explainQueryPlan(db *sql.DB, query string, args ...interface{}) (plan []string, err error) {
+	rows, err := db.Query("EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, parent, notused int
+		var detail string
+		if err = rows.Scan(&id, &parent, &notused, &detail); err != nil {
+			return
+		}
+		plan = append(plan, detail)
+	}
+	return plan, rows.Err()
+}
+
+// rowsWrittenByUpdate updates year_birth of user to its own value and
+// returns the number of rows written, including those written by triggers,
+// as counted by total_changes() on the connection running the update
+func rowsWrittenByUpdate(db *sql.DB, user string) (written int64, err error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var before, after int64
+	if err = conn.QueryRowContext(ctx, `SELECT total_changes();`).Scan(&before); err != nil {
+		return
+	}
+	if _, err = conn.ExecContext(ctx, `UPDATE user SET year_birth = year_birth WHERE user = ?;`, user); err != nil {
+		return
+	}
+	if err = conn.QueryRowContext(ctx, `SELECT total_changes();`).Scan(&after); err != nil {
+		return
+	}
+	return after - before, nil
+}
+
+// runUpdateDiagnostics runs the update phase of every selected access layer
+// with each trigger variant and with the IS NOT guard of the upsert on and
+// off, labelling the results like a sweep.  The table is reloaded with the
+// insert phase before every variant.  trg_user_update is inspected once per
+// variant and reported with printTriggerReports; the schema's own trigger is
+// restored afterwards
+func runUpdateDiagnostics(w io.Writer, data []model.User) (results []Result, err error) {
+	defer func() {
+		opt.noUpsertGuard = false
+		opt.sweepLabel = ""
+		if err2 := installTrigger(triggerSchema); err2 != nil && err == nil {
+			err = err2
+		}
+	}()
+
+	var reports []triggerReport
+	for _, variant := range triggerVariants {
+		for _, guard := range []bool{true, false} {
+			opt.noUpsertGuard = !guard
+			opt.sweepLabel = fmt.Sprintf("trigger=%s guard=%t", variant, guard)
+			for _, name := range selectedSuites() {
+				insert, ok := lookupScenario(name, phaseInsert)
+				update, ok2 := lookupScenario(name, phaseUpdate)
+				if !ok || !ok2 {
+					continue
+				}
+				log.Printf("Update diagnostics %s: %s", opt.sweepLabel, name)
+				if err = dbCleanUp(); err != nil {
+					return
+				}
+				if err = installTrigger(variant); err != nil {
+					return
+				}
+				if _, err = runScenario(insert, data); err != nil {
+					return
+				}
+
+				if len(reports) == 0 || reports[len(reports)-1].Variant != variant {
+					r, err := inspectTrigger(opt.db, variant)
+					if err != nil {
+						return results, err
+					}
+					if len(data) > 0 {
+						if r.Written, err = rowsWrittenByUpdate(opt.db, data[0].User); err != nil {
+							return results, err
+						}
+					}
+					reports = append(reports, r)
+				}
+
+				runs := *opt.warmup + *opt.iterations
+				for i := 0; i < runs; i++ {
+					res, err := runScenario(update, data)
+					if err != nil {
+						return results, err
+					}
+					if i < *opt.warmup {
+						continue
+					}
+					if *opt.verify {
+						if err = verifyPhase(&res, data); err != nil {
+							return results, err
+						}
+					}
+					res.Iteration = i - *opt.warmup + 1
+					results = append(results, res)
+				}
+			}
+		}
+	}
+	printTriggerReports(w, reports, len(data))
+	return
+}
+
+// printTriggerReports writes the definition of the schema's trg_user_update,
+// any columns it names that do not exist, and the body, query plan and rows
+// written by a single-row update for every variant to w
+func printTriggerReports(w io.Writer, reports []triggerReport, rowCount int) {
+	fmt.Fprintln(w)
+	for _, r := range reports {
+		if r.Variant != triggerSchema {
+			continue
+		}
+		fmt.Fprintf(w, "trg_user_update as created by dbCreateSchema:\n%s\n", r.DDL)
+		if len(r.Missing) > 0 {
+			fmt.Fprintf(w, "UPDATE OF names columns that are not in table user: %s\n", strings.Join(r.Missing, ", "))
+		}
+		fmt.Fprintln(w)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Trigger\tBody\tQuery plan\tRows written per update (of %d)\t\n", rowCount)
+	for _, r := range reports {
+		body, plan := r.Body, strings.Join(r.Plan, "; ")
+		if r.DDL == "" {
+			body, plan = "-", "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t\n", r.Variant, body, plan, r.Written)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MarshalJSON adds the derived wall time and throughput to the exported Result
+func (r Result) MarshalJSON() ([]byte, error) {
+	type result Result
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(struct {
+		result
+		WallTimeNs int64   `json:"wall_time_ns"`
+		OpsPerSec  float64 `json:"ops_per_sec"`
+	}{result(r), r.Duration().Nanoseconds(), r.OpsPerSec()})
+	return bytes.TrimRight(buf.Bytes(), "\n"), err
+}
+
+// outputFormatFor returns format, or the format implied by the extension of
+// path when format is empty
+func outputFormatFor(path, format string) string {
+	if format != "" {
+		return strings.ToLower(format)
+	}
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+}
+
+// writeResults writes results to path as json, jsonl or csv
+func writeResults(path, format string, results []Result) (err error) {
+	format = outputFormatFor(path, format)
+	var write func(io.Writer, []Result) error
+	switch format {
+	case "json":
+		write = writeResultsJSON
+	case "jsonl":
+		write = writeResultsJSONL
+	case "csv":
+		write = writeResultsCSV
+	case "bench", "benchstat":
+		write = writeResultsBenchstat
+	default:
+		return fmt.Errorf("unknown output format %q for %s", format, path)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err2 := f.Close(); err2 != nil && err == nil {
+			err = err2
+		}
+	}()
+	return write(f, results)
+}
+
+// writeResultsJSON writes results as a single indented JSON array
+func writeResultsJSON(w io.Writer, results []Result) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
+
+// writeResultsJSONL writes results as one JSON object per line
+func writeResultsJSONL(w io.Writer, results []Result) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, r := range results {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// csvHeader lists the columns written by writeResultsCSV
+var csvHeader = []string{
+	"scenario", "phase", "iteration", "start", "end", "ops", "statements", "rows_affected", "errors", "misses", "busy", "locked", "pool_waits", "pool_wait_ns", "commits", "wall_time_ns", "ops_per_sec",
+	"bytes_allocated", "allocs", "verified", "mismatches", "min_ns", "mean_ns", "p50_ns", "p90_ns", "p99_ns", "p999_ns", "max_ns",
+	"driver", "sqlite_version", "db_path", "dsn_options", "pragmas", "row_count", "update_count", "batch_size", "commit_every", "workers", "max_open_conns", "split_pools", "reader_conns", "mixed", "workload", "workload_ops", "workload_duration_ns", "order", "key_dist", "skew", "hot_set", "hot_ops", "group_size", "group_wait_ns", "use_transaction", "sweep",
+}
+
+// csvRecord flattens r into the columns of csvHeader
+func csvRecord(r Result) []string {
+	lat := r.Latency
+	if lat == nil {
+		lat = NewHistogram()
+	}
+	return []string{
+		r.Scenario,
+		r.Phase,
+		strconv.Itoa(r.Iteration),
+		r.Start.Format(time.RFC3339Nano),
+		r.End.Format(time.RFC3339Nano),
+		strconv.FormatInt(r.Ops, 10),
+		strconv.FormatInt(r.Statements, 10),
+		strconv.FormatInt(r.RowsAffected, 10),
+		strconv.FormatInt(r.Errors, 10),
+		strconv.FormatInt(r.Misses, 10),
+		strconv.FormatInt(r.Busy, 10),
+		strconv.FormatInt(r.Locked, 10),
+		strconv.FormatInt(r.PoolWaits, 10),
+		strconv.FormatInt(r.PoolWaitTime.Nanoseconds(), 10),
+		strconv.FormatInt(r.Commits, 10),
+		strconv.FormatInt(r.Duration().Nanoseconds(), 10),
+		strconv.FormatFloat(r.OpsPerSec(), 'f', 2, 64),
+		strconv.FormatUint(r.Bytes, 10),
+		strconv.FormatUint(r.Allocs, 10),
+		strconv.FormatBool(r.Verified),
+		strconv.FormatInt(r.Mismatches, 10),
+		strconv.FormatInt(int64(lat.Min()), 10),
+		strconv.FormatInt(int64(lat.Mean()), 10),
+		strconv.FormatInt(int64(lat.Percentile(50)), 10),
+		strconv.FormatInt(int64(lat.Percentile(90)), 10),
+		strconv.FormatInt(int64(lat.Percentile(99)), 10),
+		strconv.FormatInt(int64(lat.Percentile(99.9)), 10),
+		strconv.FormatInt(int64(lat.Max()), 10),
+		r.Config.Driver,
+		r.Config.SQLiteVersion,
+		r.Config.DBPath,
+		r.Config.DSNOptions,
+		formatPragmas(r.Config.Pragmas),
+		strconv.Itoa(r.Config.RowCount),
+		strconv.Itoa(r.Config.UpdateCount),
+		strconv.Itoa(r.Config.BatchSize),
+		strconv.Itoa(r.Config.CommitEvery),
+		strconv.Itoa(r.Config.Workers),
+		strconv.Itoa(r.Config.MaxOpenConns),
+		strconv.FormatBool(r.Config.SplitPools),
+		strconv.Itoa(r.Config.ReaderConns),
+		strconv.FormatBool(r.Config.Mixed),
+		r.Config.Workload,
+		strconv.Itoa(r.Config.WorkloadOps),
+		strconv.FormatInt(r.Config.WorkloadTime.Nanoseconds(), 10),
+		r.Config.Order,
+		r.Config.KeyDist,
+		strconv.FormatFloat(r.Config.Skew, 'g', -1, 64),
+		strconv.FormatFloat(r.Config.HotSet, 'g', -1, 64),
+		strconv.FormatFloat(r.Config.HotOps, 'g', -1, 64),
+		strconv.Itoa(r.Config.GroupSize),
+		strconv.FormatInt(r.Config.GroupWait.Nanoseconds(), 10),
+		strconv.FormatBool(r.Config.UseTransaction),
+		r.Config.Sweep,
+	}
+}
+
+// writeResultsCSV writes results as CSV with a header row
+func writeResultsCSV(w io.Writer, results []Result) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, r := range results {
+		cw.Write(csvRecord(r))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// benchmarkName returns the Go benchmark name of r, e.g. BenchmarkInsert/RawSQL/tx=true.
+// The settings of a sweep or of the update diagnostics are appended as
+// /name=value elements, so that benchstat keeps their combinations apart
+func benchmarkName(r Result) string {
+	phase := r.Phase
+	if phase != "" {
+		phase = strings.ToUpper(phase[:1]) + phase[1:]
+	}
+	name := fmt.Sprintf("Benchmark%s/%s/tx=%t", phase, r.Scenario, r.Config.UseTransaction)
+	if r.Config.CommitEvery > 0 {
+		name += fmt.Sprintf("/commitEvery=%d", r.Config.CommitEvery)
+	}
+	if r.Config.Workers > 1 {
+		name += fmt.Sprintf("/workers=%d", r.Config.Workers)
+	}
+	for _, setting := range strings.Fields(r.Config.Sweep) {
+		name += "/" + setting
+	}
+	return name
+}
+
+// writeResultsBenchstat writes results in the Go benchmark format understood
+// by golang.org/x/perf/cmd/benchstat.  Every iteration is written as its own
+// line so that benchstat can compute the variation between them.  The dsn and
+// pragmas configuration lines are repeated whenever a sweep changes them
+func writeResultsBenchstat(w io.Writer, results []Result) error {
+	fmt.Fprintf(w, "goos: %s\n", runtime.GOOS)
+	fmt.Fprintf(w, "goarch: %s\n", runtime.GOARCH)
+	fmt.Fprintf(w, "pkg: github.com/lbe/go-sql-test\n")
+	if len(results) > 0 {
+		c := results[0].Config
+		fmt.Fprintf(w, "driver: %s\n", c.Driver)
+		fmt.Fprintf(w, "sqlite: %s\n", c.SQLiteVersion)
+	}
+	dsn, pragmas := "", ""
+	for i, r := range results {
+		if i == 0 || r.Config.DSNOptions != dsn {
+			dsn = r.Config.DSNOptions
+			fmt.Fprintf(w, "dsn: %s\n", dsn)
+		}
+		if p := formatPragmas(r.Config.Pragmas); i == 0 || p != pragmas {
+			pragmas = p
+			fmt.Fprintf(w, "pragmas: %s\n", pragmas)
+		}
+		if r.Ops == 0 {
+			continue
+		}
+		_, err := fmt.Fprintf(w, "%s\t%d\t%d ns/op\t%d B/op\t%d allocs/op\n", benchmarkName(r), r.Ops,
+			r.Duration().Nanoseconds()/r.Ops, r.Bytes/uint64(r.Ops), r.Allocs/uint64(r.Ops))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeHistograms writes the latency buckets of every result to path as CSV,
+// one row per non-empty bucket, ready to be plotted
+func writeHistograms(path string, results []Result) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err2 := f.Close(); err2 != nil && err == nil {
+			err = err2
+		}
+	}()
+
+	cw := csv.NewWriter(f)
+	cw.Write([]string{"scenario", "phase", "iteration", "stage", "lower_ns", "upper_ns", "count", "cumulative_fraction"})
+	for _, r := range results {
+		writeHistogramRows(cw, r, "", r.Latency)
+		for _, st := range r.Stages {
+			writeHistogramRows(cw, r, st.Name, st.Latency)
+		}
+		writeHistogramRows(cw, r, "commit", r.CommitLatency)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeHistogramRows writes the buckets of h, the latency of stage of r or of
+// the whole operation when stage is empty, to cw
+func writeHistogramRows(cw *csv.Writer, r Result, stage string, h *Histogram) {
+	if h == nil || h.Count() == 0 {
+		return
+	}
+	var seen int64
+	for _, b := range h.Buckets() {
+		seen += b.Count
+		cw.Write([]string{
+			r.Scenario,
+			r.Phase,
+			strconv.Itoa(r.Iteration),
+			stage,
+			strconv.FormatInt(b.LowerNs, 10),
+			strconv.FormatInt(b.UpperNs, 10),
+			strconv.FormatInt(b.Count, 10),
+			strconv.FormatFloat(float64(seen)/float64(h.Count()), 'f', 6, 64),
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// histogramSubBits sets the precision of Histogram: every power of two is
+// split into 2^(histogramSubBits-1) linear buckets, bounding the error of a
+// reported value to less than 1/64 (about 1.6%)
+const histogramSubBits = 7
+
+const (
+	histogramSubCount = 1 << histogramSubBits
+	histogramHalf     = histogramSubCount / 2
+)
+
+// Histogram is an HDR-style log-linear histogram of latencies in nanoseconds.
+// It keeps a fixed relative precision over the whole range of int64 while
+// using at most a few thousand counters
+type Histogram struct {
+	counts []int64
+	total  int64
+	sum    int64
+	min    int64
+	max    int64
+}
+
+// NewHistogram returns an empty Histogram
+func NewHistogram() *Histogram {
+	return &Histogram{min: math.MaxInt64}
+}
+
+// histogramIndex returns the bucket index for v
+func histogramIndex(v int64) int {
+	if v < histogramSubCount {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - histogramSubBits
+	return shift*histogramHalf + int(v>>shift)
+}
+
+// histogramBounds returns the lowest and highest value stored in bucket idx
+func histogramBounds(idx int) (lower, upper int64) {
+	if idx < histogramSubCount {
+		return int64(idx), int64(idx)
+	}
+	shift := idx/histogramHalf - 1
+	sub := int64(idx - shift*histogramHalf)
+	return sub << shift, (sub+1)<<shift - 1
+}
+
+// Record adds a single latency to the histogram
+func (h *Histogram) Record(d time.Duration) {
+	h.RecordValue(int64(d))
+}
+
+// RecordValue adds a single value in nanoseconds to the histogram
+func (h *Histogram) RecordValue(v int64) {
+	h.recordValues(v, 1)
+}
+
+// recordValues adds n occurrences of v to the histogram
+func (h *Histogram) recordValues(v, n int64) {
+	if v < 0 {
+		v = 0
+	}
+	idx := histogramIndex(v)
+	if idx >= len(h.counts) {
+		counts := make([]int64, idx+1)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[idx] += n
+	h.total += n
+	h.sum += v * n
+	if v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+}
+
+// Merge adds all values recorded in o to h
+func (h *Histogram) Merge(o *Histogram) {
+	if o == nil || o.total == 0 {
+		return
+	}
+	if len(o.counts) > len(h.counts) {
+		counts := make([]int64, len(o.counts))
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	h.total += o.total
+	h.sum += o.sum
+	if o.min < h.min {
+		h.min = o.min
+	}
+	if o.max > h.max {
+		h.max = o.max
+	}
+}
+
+// Count returns the number of recorded values
+func (h *Histogram) Count() int64 {
+	return h.total
+}
+
+// Min returns the smallest recorded value
+func (h *Histogram) Min() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return time.Duration(h.min)
+}
+
+// Max returns the largest recorded value
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(h.max)
+}
+
+// Mean returns the exact mean of the recorded values
+func (h *Histogram) Mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / h.total)
+}
+
+// Percentile returns the value below which p percent of the recorded values
+// fall, reported as the upper bound of the bucket holding it
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p / 100 * float64(h.total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			_, upper := histogramBounds(i)
+			if upper > h.max {
+				upper = h.max
+			}
+			return time.Duration(upper)
+		}
+	}
+	return time.Duration(h.max)
+}
+
+// HistogramBucket is a non-empty bucket of an exported Histogram
+type HistogramBucket struct {
+	LowerNs int64 `json:"lower_ns"`
+	UpperNs int64 `json:"upper_ns"`
+	Count   int64 `json:"count"`
+}
+
+// Buckets returns the non-empty buckets of h in increasing order
+func (h *Histogram) Buckets() (buckets []HistogramBucket) {
+	for i, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+		lower, upper := histogramBounds(i)
+		buckets = append(buckets, HistogramBucket{lower, upper, c})
+	}
+	return
+}
+
+// histogramJSON is the exported form of a Histogram
+type histogramJSON struct {
+	Count   int64             `json:"count"`
+	MinNs   int64             `json:"min_ns"`
+	MeanNs  int64             `json:"mean_ns"`
+	P50Ns   int64             `json:"p50_ns"`
+	P90Ns   int64             `json:"p90_ns"`
+	P99Ns   int64             `json:"p99_ns"`
+	P999Ns  int64             `json:"p999_ns"`
+	MaxNs   int64             `json:"max_ns"`
+	SumNs   int64             `json:"sum_ns"`
+	Buckets []HistogramBucket `json:"buckets"`
+}
+
+// MarshalJSON exports the percentiles along with the buckets so that the
+// histogram can be plotted
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	return json.Marshal(histogramJSON{
+		Count:   h.total,
+		MinNs:   int64(h.Min()),
+		MeanNs:  int64(h.Mean()),
+		P50Ns:   int64(h.Percentile(50)),
+		P90Ns:   int64(h.Percentile(90)),
+		P99Ns:   int64(h.Percentile(99)),
+		P999Ns:  int64(h.Percentile(99.9)),
+		MaxNs:   int64(h.Max()),
+		SumNs:   h.sum,
+		Buckets: h.Buckets(),
+	})
+}
+
+// UnmarshalJSON rebuilds a Histogram from its exported buckets
+func (h *Histogram) UnmarshalJSON(b []byte) error {
+	var j histogramJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*h = *NewHistogram()
+	for _, bucket := range j.Buckets {
+		h.recordValues(bucket.LowerNs, bucket.Count)
+	}
+	if h.total > 0 {
+		h.min, h.max, h.sum = j.MinNs, j.MaxNs, j.SumNs
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	// every value around a power of two lies within the bounds of its bucket
+	for k := 0; k < 63; k++ {
+		p := int64(1) << k
+		for _, v := range []int64{p - 1, p, p + 1} {
+			if v < 0 {
+				continue
+			}
+			lower, upper := histogramBounds(histogramIndex(v))
+			if v < lower || v > upper {
+				t.Errorf("value %d in bucket %d of bounds [%d, %d]", v, histogramIndex(v), lower, upper)
+			}
+		}
+	}
+
+	// the buckets tile the values without gaps or overlaps
+	last := histogramIndex(math.MaxInt64)
+	for i := 0; i < last; i++ {
+		_, upper := histogramBounds(i)
+		lower, _ := histogramBounds(i + 1)
+		if lower != upper+1 {
+			t.Fatalf("bucket %d ends at %d but bucket %d starts at %d", i, upper, i+1, lower)
+		}
+	}
+	if _, upper := histogramBounds(last); upper != math.MaxInt64 {
+		t.Errorf("last bucket ends at %d, want %d", upper, int64(math.MaxInt64))
+	}
+}
+
+func TestHistogramPercentile(t *testing.T) {
+	tests := []struct {
+		name   string
+		values func(rng *rand.Rand) int64
+	}{
+		{"small", func(rng *rand.Rand) int64 { return rng.Int63n(200) }},
+		{"microseconds", func(rng *rand.Rand) int64 { return 10_000 + rng.Int63n(990_000) }},
+		{"log-uniform", func(rng *rand.Rand) int64 { return int64(math.Exp(rng.Float64() * 30)) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			h := NewHistogram()
+			values := make([]int64, 10000)
+			for i := range values {
+				values[i] = tt.values(rng)
+				h.RecordValue(values[i])
+			}
+			sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+			for _, p := range []float64{1, 50, 90, 99, 99.9, 100} {
+				want := values[int(math.Ceil(p/100*float64(len(values))))-1]
+				got := int64(h.Percentile(p))
+				if got < want || float64(got-want) > float64(want)/64 {
+					t.Errorf("p%g = %d, want %d within 1/64", p, got, want)
+				}
+			}
+			if h.Min() != time.Duration(values[0]) || h.Max() != time.Duration(values[len(values)-1]) {
+				t.Errorf("min/max = %d/%d, want %d/%d", h.Min(), h.Max(), values[0], values[len(values)-1])
+			}
+		})
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a, b, both := NewHistogram(), NewHistogram(), NewHistogram()
+	for i := int64(1); i <= 1000; i++ {
+		a.RecordValue(i * 1000)
+		b.RecordValue(i * 7)
+		both.RecordValue(i * 1000)
+		both.RecordValue(i * 7)
+	}
+	a.Merge(b)
+	a.Merge(nil)
+	a.Merge(NewHistogram())
+	if !reflect.DeepEqual(a, both) {
+		t.Errorf("merged histogram differs from one recording every value")
+	}
+}
+
+func TestHistogramJSONRoundTrip(t *testing.T) {
+	h := NewHistogram()
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		h.RecordValue(int64(math.Exp(rng.Float64() * 20)))
+	}
+
+	for _, src := range []*Histogram{h, NewHistogram()} {
+		b, err := json.Marshal(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Histogram
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Count() != src.Count() || got.Min() != src.Min() || got.Max() != src.Max() ||
+			got.Mean() != src.Mean() {
+			t.Errorf("count/min/max/mean = %d/%s/%s/%s, want %d/%s/%s/%s", got.Count(), got.Min(), got.Max(),
+				got.Mean(), src.Count(), src.Min(), src.Max(), src.Mean())
+		}
+		for _, p := range []float64{50, 90, 99, 99.9} {
+			if got.Percentile(p) != src.Percentile(p) {
+				t.Errorf("p%g = %s, want %s", p, got.Percentile(p), src.Percentile(p))
+			}
+		}
+		if !reflect.DeepEqual(got.Buckets(), src.Buckets()) {
+			t.Errorf("buckets differ after the round trip")
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"math/rand"
+	"strings"
+
+	"github.com/lbe/go-sql-test/gen/model"
+)
+
+// key distributions of -keyDist
+const (
+	keysSequential = "sequential" // every row in turn
+	keysUniform    = "uniform"    // every row equally likely
+	keysZipfian    = "zipfian"    // a few rows, spread over the table, are hot
+	keysLatest     = "latest"     // the rows inserted last are hot
+	keysHotspot    = "hotspot"    // the first rows are hot, all equally
+)
+
+var keyDistributions = []string{keysSequential, keysUniform, keysZipfian, keysLatest, keysHotspot}
+
+// keySeed seeds the keys drawn for the update and select phases, so that every
+// suite and iteration works on the same keys
+const keySeed = 1
+
+// keyChooser draws the index of a row out of n.  n may grow between calls as
+// rows are inserted.  A keyChooser is used by a single goroutine
+type keyChooser interface {
+	next(rng *rand.Rand, n int) int
+}
+
+// newKeyChooser returns a keyChooser for dist, one of keyDistributions.
+// A sequential chooser starts at row start
+func newKeyChooser(dist string, start int) keyChooser {
+	switch dist {
+	case keysUniform:
+		return uniformKeys{}
+	case keysZipfian:
+		return &zipfianKeys{zipfian: newZipfian(*opt.skew)}
+	case keysLatest:
+		return &latestKeys{zipfian: newZipfian(*opt.skew)}
+	case keysHotspot:
+		return hotspotKeys{hotSet: *opt.hotSet, hotOps: *opt.hotOps}
+	default:
+		return &sequentialKeys{i: start}
+	}
+}
+
+// phaseKeyDist returns the -keyDist of the update and select phases, which
+// defaults to sequential
+func phaseKeyDist() string {
+	if *opt.keyDist == "" {
+		return keysSequential
+	}
+	return *opt.keyDist
+}
+
+// workloadKeyDist returns the -keyDist of -workload, which defaults to uniform
+func workloadKeyDist() string {
+	if *opt.keyDist == "" {
+		return keysUniform
+	}
+	return *opt.keyDist
+}
+
+// drawsKeys reports whether the records of phase are drawn with drawKeys
+// rather than taken from the data in order.  Inserts always take every row
+func drawsKeys(phase string) bool {
+	return phase != phaseInsert && phaseKeyDist() != keysSequential
+}
+
+// checkKeyDist returns an error unless dist is one of keyDistributions
+func checkKeyDist(dist string) error {
+	if dist == "" {
+		return nil
+	}
+	for _, d := range keyDistributions {
+		if d == dist {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown key distribution %q, want one of %s", dist, strings.Join(keyDistributions, ", "))
+}
+
+type sequentialKeys struct{ i int }
+
+func (k *sequentialKeys) next(rng *rand.Rand, n int) int {
+	i := k.i % n
+	k.i = i + 1
+	return i
+}
+
+type uniformKeys struct{}
+
+func (uniformKeys) next(rng *rand.Rand, n int) int {
+	return rng.Intn(n)
+}
+
+// zipfianKeys draws zipfian ranks and scrambles them with a hash, so that the
+// hot rows are spread over the table instead of being its first rows
+type zipfianKeys struct {
+	*zipfian
+}
+
+func (k *zipfianKeys) next(rng *rand.Rand, n int) int {
+	h := fnv.New64a()
+	fmt.Fprint(h, k.rank(rng, n))
+	return int(h.Sum64() % uint64(n))
+}
+
+// latestKeys makes the row inserted last the hottest, followed by the one
+// before it, and so on
+type latestKeys struct {
+	*zipfian
+}
+
+func (k *latestKeys) next(rng *rand.Rand, n int) int {
+	return n - 1 - k.rank(rng, n)
+}
+
+// hotspotKeys draws uniformly from the hot set, the first hotSet fraction of
+// the rows, for a hotOps fraction of the draws and from the other rows for
+// the rest, like the YCSB hotspot generator
+type hotspotKeys struct {
+	hotSet, hotOps float64
+}
+
+func (k hotspotKeys) next(rng *rand.Rand, n int) int {
+	hot := max(1, int(k.hotSet*float64(n)))
+	if hot >= n || rng.Float64() < k.hotOps {
+		return rng.Intn(hot)
+	}
+	return hot + rng.Intn(n-hot)
+}
+
+// zipfian draws ranks in [0, n) where rank i has a probability proportional
+// to 1/(i+1)^theta, with the method of Gray et al., "Quickly Generating
+// Billion-Record Synthetic Databases", as used by YCSB.  zetan is extended
+// when n grows, so inserts do not cost a full recomputation
+type zipfian struct {
+	theta, alpha, zeta2 float64
+	n                   int
+	zetan, eta          float64
+}
+
+// newZipfian returns a zipfian of skew theta, which must be in (0, 1)
+func newZipfian(theta float64) *zipfian {
+	return &zipfian{theta: theta, alpha: 1 / (1 - theta), zeta2: zeta(0, 2, theta, 0)}
+}
+
+// zeta adds 1/(i+1)^theta for i in [from, to) to sum
+func zeta(from, to int, theta, sum float64) float64 {
+	for i := from; i < to; i++ {
+		sum += 1 / math.Pow(float64(i+1), theta)
+	}
+	return sum
+}
+
+func (z *zipfian) rank(rng *rand.Rand, n int) int {
+	if n < 2 {
+		return 0
+	}
+	if n != z.n {
+		if n > z.n {
+			z.zetan = zeta(z.n, n, z.theta, z.zetan)
+		} else {
+			z.zetan = zeta(0, n, z.theta, 0)
+		}
+		z.n = n
+		z.eta = (1 - math.Pow(2/float64(n), 1-z.theta)) / (1 - z.zeta2/z.zetan)
+	}
+	u := rng.Float64()
+	uz := u * z.zetan
+	if uz < 1 {
+		return 0
+	}
+	if uz < 1+math.Pow(0.5, z.theta) {
+		return 1
+	}
+	return min(int(float64(n)*math.Pow(z.eta*u-z.eta+1, z.alpha)), n-1)
+}
+
+// keyOrder returns data, which is sorted by user, in the order the keys of dist
+// are drawn from.  latest counts back from the row inserted last, so it draws
+// from the -order sequence
+func keyOrder(dist string, data []model.User) []model.User {
+	if dist == keysLatest {
+		return insertOrder(data)
+	}
+	return data
+}
+
+// drawKeys returns count rows of data drawn with the phaseKeyDist distribution
+func drawKeys(data []model.User, count int) []model.User {
+	data = keyOrder(phaseKeyDist(), data)
+	rng := rand.New(rand.NewSource(keySeed))
+	c := newKeyChooser(phaseKeyDist(), 0)
+	recs := make([]model.User, count)
+	distinct := map[int]bool{}
+	for i := range recs {
+		j := c.next(rng, len(data))
+		recs[i] = data[j]
+		distinct[j] = true
+	}
+	log.Printf("Drew %d %s keys, %d distinct", count, phaseKeyDist(), len(distinct))
+	return recs
+}
+
+// splitWork divides the records of phase between workers.  Drawn keys repeat,
+// so they are divided by key, which keeps the writes to a row on one worker
+// and in order
+func splitWork(phase string, recs []model.User, workers int) [][]model.User {
+	parts := make([][]model.User, workers)
+	if !drawsKeys(phase) {
+		for w := range parts {
+			parts[w] = recs[w*len(recs)/workers : (w+1)*len(recs)/workers]
+		}
+		return parts
+	}
+	for _, rec := range recs {
+		h := fnv.New32a()
+		h.Write([]byte(rec.User))
+		w := int(h.Sum32() % uint32(workers))
+		parts[w] = append(parts[w], rec)
+	}
+	return parts
+}
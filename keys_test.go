@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/lbe/go-sql-test/gen/model"
+)
+
+// setKeyOptions points the key distribution options at the given values for
+// the duration of t
+func setKeyOptions(t *testing.T, keyDist, order string, skew float64) {
+	t.Helper()
+	savedDist, savedOrder, savedSkew := opt.keyDist, opt.order, opt.skew
+	opt.keyDist, opt.order, opt.skew = &keyDist, &order, &skew
+	t.Cleanup(func() { opt.keyDist, opt.order, opt.skew = savedDist, savedOrder, savedSkew })
+}
+
+func TestDrawKeysLatestFollowsOrder(t *testing.T) {
+	data := make([]model.User, 1000)
+	for i := range data {
+		data[i].User = fmt.Sprintf("user%04d", i)
+	}
+	for _, tt := range []struct {
+		order   string
+		hottest string
+	}{
+		{orderSorted, "user0999"},
+		{orderReverse, "user0000"},
+		{orderInterleaved, "user0999"},
+		{orderRandom, insertOrderLast(t, data)},
+	} {
+		t.Run(tt.order, func(t *testing.T) {
+			setKeyOptions(t, keysLatest, tt.order, 0.99)
+			counts := map[string]int{}
+			for _, rec := range drawKeys(data, 10000) {
+				counts[rec.User]++
+			}
+			best := ""
+			for user, n := range counts {
+				if best == "" || n > counts[best] {
+					best = user
+				}
+			}
+			if best != tt.hottest {
+				t.Errorf("hottest key %s drawn %d times, want %s drawn %d times", best, counts[best], tt.hottest,
+					counts[tt.hottest])
+			}
+		})
+	}
+}
+
+// insertOrderLast returns the user inserted last with -order random
+func insertOrderLast(t *testing.T, data []model.User) string {
+	setKeyOptions(t, keysLatest, orderRandom, 0.99)
+	recs := insertOrder(data)
+	return recs[len(recs)-1].User
+}
+
+func TestZipfianRank(t *testing.T) {
+	const n, draws, theta = 1000, 200000, 0.99
+	z := newZipfian(theta)
+	rng := rand.New(rand.NewSource(1))
+	counts := make([]int, n)
+	for i := 0; i < draws; i++ {
+		r := z.rank(rng, n)
+		if r < 0 || r >= n {
+			t.Fatalf("rank %d out of [0, %d)", r, n)
+		}
+		counts[r]++
+	}
+
+	// ranks 0 and 1 are drawn in proportion to 1/(i+1)^theta, the others
+	// follow the continuous approximation of Gray et al., so only the share
+	// of the first k ranks is compared for them
+	zetan := zeta(0, n, theta, 0)
+	for i := 0; i < 2; i++ {
+		want := draws / math.Pow(float64(i+1), theta) / zetan
+		if got := float64(counts[i]); math.Abs(got-want) > 0.05*want {
+			t.Errorf("rank %d drawn %d times, want about %.0f", i, counts[i], want)
+		}
+	}
+	seen := 0
+	for k := 1; k <= n; k++ {
+		seen += counts[k-1]
+		if k != 10 && k != 100 {
+			continue
+		}
+		want := draws * zeta(0, k, theta, 0) / zetan
+		if got := float64(seen); math.Abs(got-want) > 0.1*want {
+			t.Errorf("first %d ranks drawn %d times, want about %.0f", k, seen, want)
+		}
+	}
+	for i := 1; i < 10; i++ {
+		if counts[i] > counts[i-1] {
+			t.Errorf("rank %d drawn %d times, more than rank %d drawn %d times", i, counts[i], i-1, counts[i-1])
+		}
+	}
+
+	// growing n extends zetan to the sum computed from scratch
+	z.rank(rng, 2*n)
+	if want := zeta(0, 2*n, theta, 0); math.Abs(z.zetan-want) > 1e-9*want {
+		t.Errorf("zetan = %g after growing n, want %g", z.zetan, want)
+	}
+	for _, m := range []int{0, 1} {
+		if r := z.rank(rng, m); r != 0 {
+			t.Errorf("rank of n = %d is %d, want 0", m, r)
+		}
+	}
+}
+
+func TestHotspotKeys(t *testing.T) {
+	const n, draws = 1000, 100000
+	k := hotspotKeys{hotSet: 0.2, hotOps: 0.8}
+	rng := rand.New(rand.NewSource(1))
+	hot := 0
+	seen := map[int]bool{}
+	for i := 0; i < draws; i++ {
+		j := k.next(rng, n)
+		if j < 0 || j >= n {
+			t.Fatalf("key %d out of [0, %d)", j, n)
+		}
+		if j < 200 {
+			hot++
+		}
+		seen[j] = true
+	}
+	if got := float64(hot) / draws; math.Abs(got-0.8) > 0.01 {
+		t.Errorf("%.3f of the draws in the hot set, want 0.8", got)
+	}
+	if len(seen) != n {
+		t.Errorf("%d distinct keys drawn, want all %d", len(seen), n)
+	}
+
+	// a hot set of less than one row still holds the first row
+	for _, n := range []int{1, 2} {
+		if j := (hotspotKeys{hotSet: 0.2, hotOps: 1}).next(rng, n); j != 0 {
+			t.Errorf("key %d of %d rows with every draw hot, want 0", j, n)
+		}
+	}
+}
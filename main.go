@@ -3,33 +3,75 @@ package main
 import (
 	"database/sql"
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
 	"sort"
 	"strconv"
-	//"strings"
+	"strings"
+	"time"
 
 	"github.com/go-faker/faker/v4"
-	. "github.com/go-jet/jet/v2/sqlite"
 	_ "github.com/mattn/go-sqlite3"
-	"github.com/schollz/progressbar/v3"
 
 	"github.com/lbe/go-sql-test/gen/model"
-	. "github.com/lbe/go-sql-test/gen/table"
-	"github.com/lbe/go-sql-test/models"
 )
 
 // structure in which to store command flag values and the database connection
 type opts struct {
-	db             *sql.DB
-	rowCount       *int
-	updateCount    *int
-	useBoth        *bool
-	useJet         *bool
-	useRawSQL      *bool
-	useTransaction *bool
+	db               *sql.DB
+	readDB           *sql.DB
+	baseline         *string
+	batchSize        *int
+	commitEvery      *int
+	compare          bool
+	dbPath           *string
+	diagnoseUpdate   *bool
+	dsn              string
+	dsnOptions       *string
+	effectivePragmas map[string]string
+	groupSize        *int
+	groupWait        *time.Duration
+	histogramOutput  *string
+	hotOps           *float64
+	hotSet           *float64
+	iterations       *int
+	keyDist          *string
+	maxOpenConns     *int
+	maxOpsDrop       *float64
+	maxP99Rise       *float64
+	mixed            *bool
+	noUpsertGuard    bool
+	order            *string
+	output           *string
+	outputFormat     *string
+	pragmas          pragmaList
+	queryTimeout     *time.Duration
+	readerConns      *int
+	reuse            *bool
+	rowCount         *int
+	skew             *float64
+	splitPools       *bool
+	sweep            sweepList
+	sweepLabel       string
+	updateCount      *int
+	updateStrategies strategyList
+	useBatch         *bool
+	useBoth          *bool
+	useGroupCommit   *bool
+	useJet           *bool
+	useJetPrepared   *bool
+	useRawSQL        *bool
+	useTransaction   *bool
+	verify           *bool
+	warmup           *int
+	workers          *int
+	workload         workloadMix
+	workloadDuration *time.Duration
+	workloadOps      *int
 }
 
 // structure use when calling the faker package to generate fake data
@@ -102,24 +144,54 @@ func dbCreateSchema() (err error) {
 	return
 }
 
+// writerConns returns the maximum number of open connections of opt.db.
+// SQLite has a single writer, so with -splitPools the writer pool holds one
+// connection and the read-only pool serves the selects alongside it
+func writerConns() int {
+	if *opt.splitPools {
+		return 1
+	}
+	return *opt.maxOpenConns
+}
+
 // dbInit creates a connection to the database and creates the schema if needed
 func dbInit() (err error) {
-	dbFileName := "./data/go-sql-test.sqlite"
+	dbFileName := *opt.dbPath
 	log.Printf("dbFilename = %s\n", dbFileName)
 
-	if _, err := os.Stat(dbFileName); err == nil {
-		if err2 := os.Remove(dbFileName); err2 != nil {
+	if dir := filepath.Dir(dbFileName); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
 			_, filename, line, _ := runtime.Caller(1)
 			log.Fatalf("[error] %s:%d %v", filename, line, err)
 		}
 	}
 
+	if *opt.reuse {
+		log.Printf("Reusing existing database %s, its rows will be deleted", dbFileName)
+	} else {
+		// remove the database together with any WAL files left by a previous run
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			if _, err := os.Stat(dbFileName + suffix); err == nil {
+				if err2 := os.Remove(dbFileName + suffix); err2 != nil {
+					_, filename, line, _ := runtime.Caller(1)
+					log.Fatalf("[error] %s:%d %v", filename, line, err2)
+				}
+			}
+		}
+	}
+
+	dsnOptions := *opt.dsnOptions
+	if *opt.splitPools {
+		dsnOptions = withoutSharedCache(dsnOptions)
+	}
 	dsn := dbFileName
-	dsn += "?cache=shared&_journal_mode=WAL"
-	dsn += "&_synchronous=NORMAL" // OFF added for testing
+	if dsnOptions != "" {
+		dsn += "?" + dsnOptions
+	}
 	log.Printf("dsn = %s", dsn)
+	opt.dsn = dsn
 
-	opt.db, err = sql.Open("sqlite3", dsn)
+	opt.db, err = sql.Open(driverName, dsn)
 	if err != nil {
 		log.Println("Error:", err)
 		return
@@ -136,16 +208,62 @@ func dbInit() (err error) {
 	}
 	opt.db.Close()
 
-	opt.db, err = sql.Open("sqlite3", dsn)
+	opt.db, err = sql.Open(driverName, dsn)
+	if err != nil {
+		_, filename, line, _ := runtime.Caller(1)
+		log.Fatalf("[error] %s:%d %v", filename, line, err)
+	}
+	opt.db.SetMaxOpenConns(writerConns())
+
+	if *opt.splitPools {
+		readDSN := "file:" + dbFileName + "?mode=ro"
+		if dsnOptions != "" {
+			readDSN += "&" + dsnOptions
+		}
+		log.Printf("reader dsn = %s", readDSN)
+		opt.readDB, err = sql.Open(driverName, readDSN)
+		if err != nil {
+			_, filename, line, _ := runtime.Caller(1)
+			log.Fatalf("[error] %s:%d %v", filename, line, err)
+		}
+		opt.readDB.SetMaxOpenConns(*opt.readerConns)
+	}
+
+	opt.effectivePragmas, err = readPragmas(opt.db)
 	if err != nil {
 		_, filename, line, _ := runtime.Caller(1)
 		log.Fatalf("[error] %s:%d %v", filename, line, err)
 	}
+	logPragmas(opt.effectivePragmas)
 
 	return
 }
 
-// genData generates fake data using the module faker.  The fake data is based 
+// withoutSharedCache removes cache=shared from the DSN options.  A shared
+// cache serializes the connections of the process on table locks, which
+// defeats the concurrent readers of WAL that the split pools are for
+func withoutSharedCache(dsnOptions string) string {
+	var kept []string
+	for _, o := range strings.Split(dsnOptions, "&") {
+		if o == "cache=shared" {
+			log.Print("Dropping cache=shared from the DSN options for the split pools")
+			continue
+		}
+		kept = append(kept, o)
+	}
+	return strings.Join(kept, "&")
+}
+
+// dbClose closes the writer and, if open, the reader pool
+func dbClose() {
+	opt.db.Close()
+	if opt.readDB != nil {
+		opt.readDB.Close()
+		opt.readDB = nil
+	}
+}
+
+// genData generates fake data using the module faker.  The fake data is based
 // upon the structFakeData structure,  The number of rows created defined
 // by the rowCount command line flag and defaults to 100009
 func genData() (fakeData []model.User, err error) {
@@ -173,407 +291,125 @@ func genData() (fakeData []model.User, err error) {
 	return
 }
 
-// insertWithRawSQLUpsert performs the RawSQL insert scenario
-func insertWithRawSQLUpsert(data []model.User) {
-	log.Println("Executing insertWithRawSQLUpsert")
-
-	var tx *sql.Tx
-	if *opt.useTransaction {
-		// Get a Tx for making transaction requests.
-		var err error
-		tx, err = opt.db.Begin()
-		if err != nil {
-			_, filename, line, _ := runtime.Caller(1)
-			log.Fatalf("[error] %s:%d %v", filename, line, err)
-		}
-		// Defer a rollback in case anything fails.
-		defer tx.Rollback()
-	}
-
-	upsertUser := models.StmtUpsertUser(opt.db)
-	bar := progressbar.Default(int64(len(data)))
-	for _, rec := range data {
-		if *opt.useTransaction {
-			_, err := tx.Stmt(upsertUser()).Exec(rec.User, rec.City, rec.Region, rec.Country, rec.AreaCode,
-				rec.ZipCode, rec.YearBirth, rec.Im, rec.Name)
-			if err != nil {
-				_, filename, line, _ := runtime.Caller(1)
-				log.Fatalf("[error] %s:%d %v", filename, line, err)
-			}
-		} else {
-			_, err := upsertUser().Exec(rec.User, rec.City, rec.Region, rec.Country, rec.AreaCode,
-				rec.ZipCode, rec.YearBirth, rec.Im, rec.Name)
-			if err != nil {
-				_, filename, line, _ := runtime.Caller(1)
-				log.Fatalf("[error] %s:%d %v", filename, line, err)
-			}
-		}
-		bar.Add(1)
-	}
-	bar.Finish()
+func main() {
+	log.Println("Execution Starting")
 
-	if *opt.useTransaction {
-		log.Print("Commit Start")
-		err := tx.Commit()
-		if err != nil {
-			_, filename, line, _ := runtime.Caller(1)
-			log.Fatalf("[error] %s:%d %v", filename, line, err)
+	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to file")
+	opt.baseline = flag.String("baseline", "", "compare: results file (json or jsonl) to compare against")
+	opt.batchSize = flag.Int("batchSize", 100, "Number of rows per statement in the batch insert scenarios")
+	opt.commitEvery = flag.Int("commitEvery", 0, "Commit and begin a new transaction every N rows (0 for one transaction with -useTransaction)")
+	opt.dbPath = flag.String("dbPath", "./data/go-sql-test.sqlite", "SQLite database file, its directory is created if needed")
+	opt.diagnoseUpdate = flag.Bool("diagnoseUpdate", false, "Explain trg_user_update and run the update phase with and without it and the IS NOT guard")
+	opt.dsnOptions = flag.String("dsnOptions", "cache=shared&_journal_mode=WAL&_synchronous=NORMAL", "query string appended to the database file name in the DSN")
+	opt.groupSize = flag.Int("groupSize", 100, "Number of requests after which the group commit writer commits")
+	opt.groupWait = flag.Duration("groupWait", time.Millisecond, "Time after the first request of a group after which the group commit writer commits")
+	opt.histogramOutput = flag.String("histogramOutput", "", "write latency histogram buckets to CSV file")
+	opt.hotOps = flag.Float64("hotOps", 0.8, "Fraction of the draws of the hotspot key distribution that go to the hot set")
+	opt.hotSet = flag.Float64("hotSet", 0.2, "Fraction of the rows, the first ones, in the hot set of the hotspot key distribution")
+	opt.iterations = flag.Int("iterations", 1, "Number of measured runs of each scenario")
+	opt.keyDist = flag.String("keyDist", "", "Key distribution of the updates, selects and -workload: sequential, uniform, zipfian, latest or hotspot (default sequential, uniform for -workload)")
+	opt.maxOpenConns = flag.Int("maxOpenConns", 0, "Maximum number of open connections in the database/sql pool (0 for unlimited)")
+	opt.maxOpsDrop = flag.Float64("maxOpsDrop", 10, "compare: maximum throughput drop in percent before failing")
+	opt.maxP99Rise = flag.Float64("maxP99Rise", 20, "compare: maximum p99 latency rise in percent before failing")
+	opt.mixed = flag.Bool("mixed", false, "Run the select phase of each module alongside its insert and update phases")
+	opt.order = flag.String("order", orderSorted, "Order of the inserts: sorted, random, reverse or interleaved by user; updates and selects stay sorted, but -keyDist latest draws in this order")
+	opt.output = flag.String("output", "", "write results to file")
+	opt.outputFormat = flag.String("outputFormat", "", "format of -output file: json, jsonl, csv or benchstat (default from file extension)")
+	flag.Var(&opt.pragmas, "pragma", "PRAGMA name=value applied to every connection, e.g. journal_mode=WAL (repeatable)")
+	opt.queryTimeout = flag.Duration("queryTimeout", 0, "run every operation under a context with this timeout (0 for none)")
+	opt.readerConns = flag.Int("readerConns", 4, "Maximum number of open connections in the read-only pool of -splitPools")
+	opt.reuse = flag.Bool("reuse", false, "Reuse the existing database file instead of deleting it; its rows are still deleted")
+	opt.rowCount = flag.Int("rowCount", 10000, "Number of rows to use in test")
+	opt.skew = flag.Float64("skew", 0.99, "Skew of the zipfian and latest key distributions, between 0 and 1")
+	opt.splitPools = flag.Bool("splitPools", false, "Open a single-connection writer pool and a read-only reader pool used by the selects")
+	flag.Var(&opt.sweep, "sweep", "sweep over name=value1,value2,... where name is a PRAGMA, useTransaction, batchSize, commitEvery, workers, maxOpenConns or order (repeatable)")
+	opt.updateCount = flag.Int("updateCount", 1000, "Maximum number of updates to perform")
+	flag.Var(&opt.updateStrategies, "updateStrategies", "also run the update phase with these alternative strategies: plain, replace, selectUpdate, tempTable or all (repeatable)")
+	opt.useBatch = flag.Bool("useBatch", false, "Also run the multi-row batch insert scenario of each selected module")
+	opt.useBoth = flag.Bool("useBoth", false, "Run both RawSql and Jet")
+	opt.useGroupCommit = flag.Bool("useGroupCommit", false, "Also run the RawSQL and Jet upserts through a single writer with group commit")
+	opt.useJet = flag.Bool("useJet", false, "Run using Jet module")
+	opt.useJetPrepared = flag.Bool("useJetPrepared", false, "Run using Jet with prepared statement cache")
+	opt.useRawSQL = flag.Bool("useRawSQL", false, "Run using RawSQL module")
+	opt.useTransaction = flag.Bool("useTransaction", false, "Wrap work in transaction")
+	opt.verify = flag.Bool("verify", false, "Read every row back after insert and update and compare it with the generated data")
+	opt.warmup = flag.Int("warmup", 0, "Number of unmeasured warmup runs of each scenario")
+	opt.workers = flag.Int("workers", 1, "Number of goroutines sharing the database that every phase's records are split across")
+	flag.Var(&opt.workload, "workload", "run a mixed workload of op=weight,... with op select, update, insert or rmw, or YCSB workload a, b, c, d or f, instead of the phases")
+	opt.workloadDuration = flag.Duration("workloadDuration", 0, "Run the -workload for this long")
+	opt.workloadOps = flag.Int("workloadOps", 0, "Number of operations of the -workload (default -rowCount unless -workloadDuration is set)")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [compare] [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "compare" {
+		opt.compare = true
+		args = args[1:]
+	}
+	flag.CommandLine.Parse(args)
+
+	var baseline []Result
+	if opt.compare {
+		if *opt.baseline == "" {
+			log.Fatalf("[error] compare requires -baseline")
 		}
-		log.Print("Commit Finished")
-	}
-}
-
-// updateWithRawSQLUpsert performs the RawSQL update scenario
-func updateWithRawSQLUpsert(data []model.User) {
-	if *opt.updateCount == 0 {
-		return
-	}
-	log.Println("Executing updateWithRawSQLUpsert")
-
-	var tx *sql.Tx
-	if *opt.useTransaction {
-		// Get a Tx for making transaction requests.
 		var err error
-		tx, err = opt.db.Begin()
-		if err != nil {
+		if baseline, err = loadResults(*opt.baseline); err != nil {
 			_, filename, line, _ := runtime.Caller(1)
 			log.Fatalf("[error] %s:%d %v", filename, line, err)
 		}
-		// Defer a rollback in case anything fails.
-		defer tx.Rollback()
+		log.Printf("Loaded %d baseline results from %s", len(baseline), *opt.baseline)
 	}
 
-	updateCount := 0
-	upsertUser := models.StmtUpsertUser(opt.db)
-	bar := progressbar.Default(int64(*opt.updateCount)) //(len(data)))
-	for _, rec := range data {
-		updateCount++
-		if updateCount >= *opt.updateCount {
-			break
-		}
-		*rec.YearBirth--
-		if *opt.useTransaction {
-			_, err := tx.Stmt(upsertUser()).Exec(rec.User, rec.City, rec.Region, rec.Country, rec.AreaCode,
-				rec.ZipCode, rec.YearBirth, rec.Im, rec.Name)
-			if err != nil {
-				_, filename, line, _ := runtime.Caller(1)
-				log.Fatalf("[error] %s:%d %v", filename, line, err)
-			}
-		} else {
-			_, err := upsertUser().Exec(rec.User, rec.City, rec.Region, rec.Country, rec.AreaCode,
-				rec.ZipCode, rec.YearBirth, rec.Im, rec.Name)
-			if err != nil {
-				_, filename, line, _ := runtime.Caller(1)
-				log.Fatalf("[error] %s:%d %v", filename, line, err)
-			}
-		}
-		bar.Add(1)
+	if *opt.iterations < 1 || *opt.warmup < 0 {
+		log.Fatalf("[error] -iterations must be at least 1 and -warmup at least 0")
 	}
-	bar.Finish()
-
-	if *opt.useTransaction {
-		log.Print("Commit Start")
-		err := tx.Commit()
-		if err != nil {
-			_, filename, line, _ := runtime.Caller(1)
-			log.Fatalf("[error] %s:%d %v", filename, line, err)
-		}
-		log.Print("Commit Finished")
+	if *opt.diagnoseUpdate && len(opt.sweep) > 0 {
+		log.Fatalf("[error] -diagnoseUpdate cannot be combined with -sweep")
 	}
-}
-
-// selectWithRawSQLUpsert performs the RawSQL select scenario
-func selectWithRawSQLUpsert(data []model.User) {
-	log.Println("Executing selectWithRawSQLUpsert")
-
-	var tx *sql.Tx
-	if *opt.useTransaction {
-		// Get a Tx for making transaction requests.
-		var err error
-		tx, err = opt.db.Begin()
-		if err != nil {
-			_, filename, line, _ := runtime.Caller(1)
-			log.Fatalf("[error] %s:%d %v", filename, line, err)
-		}
-		// Defer a rollback in case anything fails.
-		defer tx.Rollback()
-	}
-
-	selectUser := models.StmtSelectUser(opt.db)
-	bar := progressbar.Default(int64(len(data)))
-	for _, rec := range data {
-		var row models.RawSqlUser
-		if *opt.useTransaction {
-			err := tx.Stmt(selectUser()).QueryRow(rec.User).Scan(&row.User, &row.City, &row.Region, &row.Country, &row.AreaCode,
-				&row.ZipCode, &row.YearBirth, &row.Im, &row.Name, &row.CreatedTst, &row.ChangedTst)
-			if err != nil {
-				if err == sql.ErrNoRows {
-					_, filename, line, _ := runtime.Caller(1)
-					log.Printf("[warning] %s:%d %v in for user = %s", filename, line, err, rec.User)
-				}
-				_, filename, line, _ := runtime.Caller(1)
-				log.Fatalf("[error] %s:%d %v", filename, line, err)
-			}
-		} else {
-			err := selectUser().QueryRow(rec.User).Scan(&row.User, &row.City, &row.Region, &row.Country, &row.AreaCode,
-				&row.ZipCode, &row.YearBirth, &row.Im, &row.Name, &row.CreatedTst, &row.ChangedTst)
-			if err != nil {
-				if err == sql.ErrNoRows {
-					_, filename, line, _ := runtime.Caller(1)
-					log.Printf("[warning] %s:%d %v in for user = %s", filename, line, err, rec.User)
-				}
-				_, filename, line, _ := runtime.Caller(1)
-				log.Fatalf("[error] %s:%d %v", filename, line, err)
-			}
-		}
-		bar.Add(1)
+	if *opt.workers < 1 || *opt.maxOpenConns < 0 {
+		log.Fatalf("[error] -workers must be at least 1 and -maxOpenConns at least 0")
 	}
-	bar.Finish()
-
-	if *opt.useTransaction {
-		log.Print("Commit Start")
-		err := tx.Commit()
-		if err != nil {
-			_, filename, line, _ := runtime.Caller(1)
-			log.Fatalf("[error] %s:%d %v", filename, line, err)
-		}
-		log.Print("Commit Finished")
+	if err := checkOrder(*opt.order); err != nil {
+		log.Fatalf("[error] -order: %v", err)
 	}
-}
-
-// insertWithJetUpsert performs the Jet insert scenario
-func insertWithJet(data []model.User) {
-	log.Println("Executing insertWithJet")
-
-	var tx *sql.Tx
-	if *opt.useTransaction {
-		// Get a Tx for making transaction requests.
-		var err error
-		tx, err = opt.db.Begin()
-		if err != nil {
-			_, filename, line, _ := runtime.Caller(1)
-			log.Fatalf("[error] %s:%d %v", filename, line, err)
-		}
-		// Defer a rollback in case anything fails.
-		defer tx.Rollback()
+	if err := checkKeyDist(*opt.keyDist); err != nil {
+		log.Fatalf("[error] -keyDist: %v", err)
 	}
-
-	bar := progressbar.Default(int64(len(data)))
-	for _, rec := range data {
-
-		columnList := ColumnList{
-			User.User, User.City, User.Region, User.Country, User.AreaCode, User.ZipCode,
-			User.YearBirth, User.Im, User.Name,
-		}
-
-		stmtInserUser := User.INSERT(columnList).
-			MODEL(rec).
-			ON_CONFLICT(User.User).
-			DO_UPDATE(
-				SET(
-					User.City.SET(User.EXCLUDED.City),
-					User.Country.SET(User.EXCLUDED.Country),
-					User.AreaCode.SET(User.EXCLUDED.AreaCode),
-					User.ZipCode.SET(User.EXCLUDED.ZipCode),
-					User.YearBirth.SET(User.EXCLUDED.YearBirth),
-					User.Im.SET(User.EXCLUDED.Im),
-					User.Name.SET(User.EXCLUDED.Name),
-				).WHERE(
-					OR(User.Country.IS_DISTINCT_FROM(User.EXCLUDED.Country)).
-						OR(User.AreaCode.IS_DISTINCT_FROM(User.EXCLUDED.AreaCode)).
-						OR(User.ZipCode.IS_DISTINCT_FROM(User.EXCLUDED.ZipCode)).
-						OR(User.YearBirth.IS_DISTINCT_FROM(User.EXCLUDED.YearBirth)).
-						OR(User.Im.IS_DISTINCT_FROM(User.EXCLUDED.Im)).
-						OR(User.Name.IS_DISTINCT_FROM(User.EXCLUDED.Name)),
-				),
-			)
-
-		// sql_debug := stmtInserUser.DebugSql()
-		// fmt.Println(sql_debug)
-		if *opt.useTransaction {
-			_, err := stmtInserUser.Exec(tx)
-			if err != nil {
-				_, filename, line, _ := runtime.Caller(1)
-				log.Fatalf("[error] %s:%d %v", filename, line, err)
-			}
-		} else {
-			_, err := stmtInserUser.Exec(opt.db)
-			if err != nil {
-				_, filename, line, _ := runtime.Caller(1)
-				log.Fatalf("[error] %s:%d %v", filename, line, err)
-			}
-		}
-		bar.Add(1)
+	if *opt.skew <= 0 || *opt.skew >= 1 {
+		log.Fatalf("[error] -skew must be between 0 and 1")
 	}
-	bar.Finish()
-
-	if *opt.useTransaction {
-		log.Print("Commit Start")
-		tx.Commit()
-		log.Print("Commit Finished")
+	if *opt.hotSet <= 0 || *opt.hotSet >= 1 || *opt.hotOps < 0 || *opt.hotOps > 1 {
+		log.Fatalf("[error] -hotSet must be between 0 and 1 and -hotOps from 0 to 1")
 	}
-
-	return
-}
-
-// updateWithRawSQLUpsert performs the RawSQL update scenario
-func updateWithJet(data []model.User) {
-	if *opt.updateCount == 0 {
-		return
-	} 
-	log.Println("Executing updateWithJet")
-
-	var tx *sql.Tx
-	if *opt.useTransaction {
-		// Get a Tx for making transaction requests.
-		var err error
-		tx, err = opt.db.Begin()
-		if err != nil {
-			_, filename, line, _ := runtime.Caller(1)
-			log.Fatalf("[error] %s:%d %v", filename, line, err)
-		}
-		// Defer a rollback in case anything fails.
-		defer tx.Rollback()
+	if *opt.workloadOps < 0 || *opt.workloadDuration < 0 {
+		log.Fatalf("[error] -workloadOps and -workloadDuration must not be negative")
 	}
-
-	updateCount := 0
-	bar := progressbar.Default(int64(*opt.updateCount)) //(len(data))) 
-	for _, rec := range data { 
-		updateCount++
-		if updateCount >= *opt.updateCount {
-			break
-		}
-		*rec.YearBirth-- 
-
-		columnList := ColumnList{
-			User.User, User.City, User.Region, User.Country, User.AreaCode, User.ZipCode,
-			User.YearBirth, User.Im, User.Name,
-		}
-
-		stmtInserUser := User.INSERT(columnList).
-			MODEL(rec).
-			ON_CONFLICT(User.User).
-			DO_UPDATE(
-				SET(
-					User.City.SET(User.EXCLUDED.City),
-					User.Country.SET(User.EXCLUDED.Country),
-					User.AreaCode.SET(User.EXCLUDED.AreaCode),
-					User.ZipCode.SET(User.EXCLUDED.ZipCode),
-					User.YearBirth.SET(User.EXCLUDED.YearBirth),
-					User.Im.SET(User.EXCLUDED.Im),
-					User.Name.SET(User.EXCLUDED.Name),
-				).WHERE(
-					OR(User.Country.IS_DISTINCT_FROM(User.EXCLUDED.Country)).
-						OR(User.AreaCode.IS_DISTINCT_FROM(User.EXCLUDED.AreaCode)).
-						OR(User.ZipCode.IS_DISTINCT_FROM(User.EXCLUDED.ZipCode)).
-						OR(User.YearBirth.IS_DISTINCT_FROM(User.EXCLUDED.YearBirth)).
-						OR(User.Im.IS_DISTINCT_FROM(User.EXCLUDED.Im)).
-						OR(User.Name.IS_DISTINCT_FROM(User.EXCLUDED.Name)),
-				),
-			)
-
-		// sql_debug := stmtInserUser.DebugSql()
-		// fmt.Println(sql_debug)
-		if *opt.useTransaction {
-			_, err := stmtInserUser.Exec(tx)
-			if err != nil {
-				_, filename, line, _ := runtime.Caller(1)
-				log.Fatalf("[error] %s:%d %v", filename, line, err)
-			}
-		} else {
-			_, err := stmtInserUser.Exec(opt.db)
-			if err != nil {
-				_, filename, line, _ := runtime.Caller(1)
-				log.Fatalf("[error] %s:%d %v", filename, line, err)
-			}
-		}
-		bar.Add(1)
+	if len(opt.workload) > 0 && (*opt.mixed || *opt.diagnoseUpdate) {
+		log.Fatalf("[error] -workload cannot be combined with -mixed or -diagnoseUpdate")
 	}
-	bar.Finish()
-
-	if *opt.useTransaction {
-		log.Print("Commit Start")
-		tx.Commit()
-		log.Print("Commit Finished")
+	if *opt.splitPools && (*opt.maxOpenConns > 1 || opt.sweep.has(sweepMaxOpenConns)) {
+		log.Fatalf("[error] -splitPools holds a single writer connection and cannot be combined with -maxOpenConns")
 	}
-
-	return
-}
-
-// selectWithRawSQLUpsert performs the RawSQL select scenario
-func selectWithJet(data []model.User) {
-	log.Println("Executing selectWithJet")
-
-	var tx *sql.Tx
-	if *opt.useTransaction {
-		// Get a Tx for making transaction requests.
-		var err error
-		tx, err = opt.db.Begin()
-		if err != nil {
-			_, filename, line, _ := runtime.Caller(1)
-			log.Fatalf("[error] %s:%d %v", filename, line, err)
-		}
-		// Defer a rollback in case anything fails.
-		defer tx.Rollback()
+	if *opt.readerConns < 1 {
+		log.Fatalf("[error] -readerConns must be at least 1")
 	}
-
-	bar := progressbar.Default(int64(len(data)))
-	for _, rec := range data {
-
-		columnList := ColumnList{
-			User.User, User.City, User.Region, User.Country, User.AreaCode, User.ZipCode,
-			User.YearBirth, User.Im, User.Name, User.CreatedTst, User.ChangedTst,
-		}
-
-		stmtInserUser := User.SELECT(columnList).
-			FROM(User).
-			WHERE(User.User.EQ(String(rec.User)))
-
-		// sql_debug := stmtInserUser.DebugSql()
-		// fmt.Println(sql_debug)
-		if *opt.useTransaction {
-			_, err := stmtInserUser.Exec(tx)
-			if err != nil {
-				_, filename, line, _ := runtime.Caller(1)
-				log.Fatalf("[error] %s:%d %v", filename, line, err)
-			}
-		} else {
-			_, err := stmtInserUser.Exec(opt.db)
-			if err != nil {
-				_, filename, line, _ := runtime.Caller(1)
-				log.Fatalf("[error] %s:%d %v", filename, line, err)
-			}
-		}
-		bar.Add(1)
+	if *opt.groupSize < 1 || *opt.groupWait <= 0 {
+		log.Fatalf("[error] -groupSize and -groupWait must be positive")
 	}
-	bar.Finish()
-
-	if *opt.useTransaction {
-		log.Print("Commit Start")
-		tx.Commit()
-		log.Print("Commit Finished")
+	if *opt.commitEvery < 0 {
+		log.Fatalf("[error] -commitEvery must be at least 0")
+	}
+	if *opt.batchSize < 1 || *opt.batchSize > maxBatchSize {
+		log.Fatalf("[error] -batchSize must be 1 to %d", maxBatchSize)
 	}
-}
-
-func main() {
-	log.Println("Execution Starting")
-
-	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to file")
-	opt.rowCount = flag.Int("rowCount", 10000, "Number of rows to use in test")
-	opt.updateCount = flag.Int("updateCount", 1000, "Maximum number of updates to perform")
-	opt.useBoth = flag.Bool("useBoth", false, "Run both RawSql and Jet")
-	opt.useJet = flag.Bool("useJet", false, "Run using Jet module")
-	opt.useRawSQL = flag.Bool("useRawSQL", false, "Run using RawSQL module")
-	opt.useTransaction = flag.Bool("useTransaction", false, "Wrap work in transaction")
-
-	flag.Parse()
 
 	if *opt.useBoth {
 		*opt.useRawSQL = true
 		*opt.useJet = true
-	} else if *opt.useJet {
-		*opt.useRawSQL = false
-	} else {
+	} else if !*opt.useJet && !*opt.useJetPrepared {
 		*opt.useRawSQL = true
 	}
 
@@ -592,7 +428,7 @@ func main() {
 		_, filename, line, _ := runtime.Caller(1)
 		log.Fatalf("[error] %s:%d %v", filename, line, err)
 	}
-	defer opt.db.Close()
+	defer dbClose()
 
 	err = dbCleanUp()
 	if err != nil {
@@ -612,24 +448,65 @@ func main() {
 	})
 	log.Println("Sort data Ended")
 
-	if *opt.useRawSQL {
-		insertWithRawSQLUpsert(data)
-		updateWithRawSQLUpsert(data)
-		selectWithRawSQLUpsert(data)
+	var results []Result
+	if len(opt.sweep) > 0 {
+		results, err = runSweep(data)
+	} else if *opt.diagnoseUpdate {
+		results, err = runUpdateDiagnostics(os.Stdout, data)
+	} else {
+		results, err = runSuites(data)
+	}
+	if err != nil {
+		_, filename, line, _ := runtime.Caller(1)
+		log.Fatalf("[error] %s:%d %v", filename, line, err)
+	}
+
+	if len(opt.sweep) > 0 || *opt.diagnoseUpdate {
+		printSweepSummary(os.Stdout, results)
+	} else {
+		printSummary(os.Stdout, results)
+		if *opt.iterations > 1 {
+			printStats(os.Stdout, results)
+		}
+		printStages(os.Stdout, results)
+	}
+	printContention(os.Stdout, results)
+	if *opt.output != "" {
+		if err = writeResults(*opt.output, *opt.outputFormat, results); err != nil {
+			_, filename, line, _ := runtime.Caller(1)
+			log.Fatalf("[error] %s:%d %v", filename, line, err)
+		}
+		log.Printf("Results written to %s", *opt.output)
+	}
+	if *opt.histogramOutput != "" {
+		if err = writeHistograms(*opt.histogramOutput, results); err != nil {
+			_, filename, line, _ := runtime.Caller(1)
+			log.Fatalf("[error] %s:%d %v", filename, line, err)
+		}
+		log.Printf("Latency histograms written to %s", *opt.histogramOutput)
+	}
+	for _, r := range results {
+		if r.Errors > 0 {
+			log.Fatalf("[error] %s %s had %d failed operations", r.Scenario, r.Phase, r.Errors)
+		}
+		if r.Mismatches > 0 {
+			log.Fatalf("[error] %s %s left %d rows that do not match the generated data", r.Scenario, r.Phase, r.Mismatches)
+		}
 	}
 
-	if *opt.useJet {
-		if *opt.useBoth {
-			err = dbCleanUp()
-			if err != nil {
-				_, filename, line, _ := runtime.Caller(1)
-				log.Fatalf("[error] %s:%d %v", filename, line, err)
+	if opt.compare {
+		if len(baseline) > 0 && len(results) > 0 {
+			for _, d := range configDifferences(baseline[0].Config, results[0].Config) {
+				log.Printf("[warning] baseline was run with a different configuration: %s", d)
+			}
+		}
+		comps := compareResults(baseline, results, *opt.maxOpsDrop, *opt.maxP99Rise)
+		printComparison(os.Stdout, comps)
+		for _, c := range comps {
+			if len(c.Regressed) > 0 {
+				log.Fatalf("[error] %s %s regressed against %s", c.Scenario, c.Phase, *opt.baseline)
 			}
-			log.Print("Reset database for Jet")
 		}
-		insertWithJet(data)
-		updateWithJet(data)
-		selectWithJet(data)
 	}
 
 	log.Println("Execution Completed")
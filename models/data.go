@@ -3,6 +3,7 @@ package models
 import (
 	"database/sql"
 	"log"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -23,40 +24,7 @@ type RawSqlUser struct {
 }
 
 func StmtUpsertUser(db *sql.DB) func() *sql.Stmt {
-	const sqlUpsertUser string = `
-		INSERT INTO user (
-			user
-			, city
-			, region
-			, country
-			, area_code
-			, zip_code
-			, year_birth
-			, im
-			, name
-		)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT (user)	
-		DO UPDATE 
-		      SET city        = excluded.city
-			    , region      = excluded.region
-			    , country     = excluded.country
-			    , area_code   = excluded.area_code
-			    , zip_code    = excluded.zip_code
-			    , year_birth  = excluded.year_birth
-			    , im          = excluded.im
-			    , name = excluded.name
-		    WHERE city       IS NOT excluded.city
-		       OR region      IS NOT excluded.region
-		       OR country     IS NOT excluded.country
-		       OR area_code   IS NOT excluded.area_code
-		       OR zip_code    IS NOT excluded.zip_code
-		       OR year_birth  IS NOT excluded.year_birth
-		       OR im          IS NOT excluded.im
-		       OR name IS NOT excluded.name
-	;`
-
-	stmt, err := db.Prepare(sqlUpsertUser)
+	stmt, err := db.Prepare(SqlUpsertUsers(1))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -91,4 +59,136 @@ func StmtSelectUser(db *sql.DB) func() *sql.Stmt {
 	return func() *sql.Stmt {
 		return stmt
 	}
-}
\ No newline at end of file
+}
+
+// SqlUpsertUsers returns the upsert of n rows at once with a multi-row VALUES
+// clause.  StmtUpsertUser prepares it for a single row
+func SqlUpsertUsers(n int) string {
+	return sqlUpsertUsers(n, true)
+}
+
+// SqlUpsertUsersNoGuard returns SqlUpsertUsers without the IS NOT guard, so
+// that a conflicting row is updated even when no column changes
+func SqlUpsertUsersNoGuard(n int) string {
+	return sqlUpsertUsers(n, false)
+}
+
+// sqlUpsertUsersGuard is the DO UPDATE WHERE clause skipping no-op updates
+const sqlUpsertUsersGuard = `
+		    WHERE city       IS NOT excluded.city
+		       OR region      IS NOT excluded.region
+		       OR country     IS NOT excluded.country
+		       OR area_code   IS NOT excluded.area_code
+		       OR zip_code    IS NOT excluded.zip_code
+		       OR year_birth  IS NOT excluded.year_birth
+		       OR im          IS NOT excluded.im
+		       OR name IS NOT excluded.name`
+
+func sqlUpsertUsers(n int, guard bool) string {
+	values := make([]string, n)
+	for i := range values {
+		values[i] = "(?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	}
+	where := ""
+	if guard {
+		where = sqlUpsertUsersGuard
+	}
+	return `
+		INSERT INTO user (
+			user
+			, city
+			, region
+			, country
+			, area_code
+			, zip_code
+			, year_birth
+			, im
+			, name
+		)
+		VALUES ` + strings.Join(values, "\n\t\t     , ") + `
+		ON CONFLICT (user)
+		DO UPDATE
+		      SET city        = excluded.city
+			    , region      = excluded.region
+			    , country     = excluded.country
+			    , area_code   = excluded.area_code
+			    , zip_code    = excluded.zip_code
+			    , year_birth  = excluded.year_birth
+			    , im          = excluded.im
+			    , name = excluded.name` + where + `
+	;`
+}
+
+// SqlUpdateUser updates every column of an existing user with a plain UPDATE.
+// The user is the last parameter
+const SqlUpdateUser = `
+		UPDATE user
+		   SET city        = ?
+		     , region      = ?
+		     , country     = ?
+		     , area_code   = ?
+		     , zip_code    = ?
+		     , year_birth  = ?
+		     , im          = ?
+		     , name        = ?
+		 WHERE "user" = ?
+	;`
+
+// SqlReplaceUser writes a user with INSERT OR REPLACE, which deletes a
+// conflicting row and inserts a new one, so created_tst is reset and the
+// update trigger does not fire
+const SqlReplaceUser = `
+		INSERT OR REPLACE INTO user (
+			user
+			, city
+			, region
+			, country
+			, area_code
+			, zip_code
+			, year_birth
+			, im
+			, name
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	;`
+
+// SqlCreateUserStage creates the connection's TEMP staging table for bulk
+// updates with SqlUpdateUserFromStage
+const SqlCreateUserStage = `
+	CREATE TEMP TABLE IF NOT EXISTS user_stage (
+			"user" TEXT NOT NULL PRIMARY KEY,
+			city TEXT,
+			region TEXT,
+			country TEXT,
+			area_code TEXT,
+			zip_code TEXT,
+			year_birth INTEGER,
+			im TEXT,
+			name TEXT,
+			created_tst DATETIME,
+			changed_tst DATETIME
+		);`
+
+// SqlInsertUserStage stages one user for SqlUpdateUserFromStage
+const SqlInsertUserStage = `
+		INSERT INTO temp.user_stage (user, city, region, country, area_code, zip_code, year_birth, im, name)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	;`
+
+// SqlUpdateUserFromStage updates every staged user with a single UPDATE ... FROM
+const SqlUpdateUserFromStage = `
+		UPDATE user
+		   SET city        = stage.city
+		     , region      = stage.region
+		     , country     = stage.country
+		     , area_code   = stage.area_code
+		     , zip_code    = stage.zip_code
+		     , year_birth  = stage.year_birth
+		     , im          = stage.im
+		     , name        = stage.name
+		  FROM temp.user_stage AS stage
+		 WHERE user."user" = stage."user"
+	;`
+
+// SqlDeleteUserStage empties the staging table
+const SqlDeleteUserStage = `DELETE FROM temp.user_stage;`
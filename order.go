@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/lbe/go-sql-test/gen/model"
+)
+
+// insert orders of -order.  The data is sorted by user, the primary key, so
+// sorted inserts append at the right edge of the table's B-tree while the
+// others split pages all over it
+const (
+	orderSorted      = "sorted"      // ascending user
+	orderRandom      = "random"      // shuffled
+	orderReverse     = "reverse"     // descending user
+	orderInterleaved = "interleaved" // alternately from the first and second half
+)
+
+var insertOrders = []string{orderSorted, orderRandom, orderReverse, orderInterleaved}
+
+// orderSeed seeds the random insert order, so that every suite and iteration
+// inserts the rows in the same order
+const orderSeed = 1
+
+// checkOrder returns an error unless order is one of insertOrders
+func checkOrder(order string) error {
+	for _, o := range insertOrders {
+		if o == order {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown insert order %q, want one of %s", order, strings.Join(insertOrders, ", "))
+}
+
+// insertOrder returns data, which is sorted by user, in the -order order
+func insertOrder(data []model.User) []model.User {
+	recs := make([]model.User, 0, len(data))
+	switch *opt.order {
+	case orderRandom:
+		recs = append(recs, data...)
+		rand.New(rand.NewSource(orderSeed)).Shuffle(len(recs), func(i, j int) {
+			recs[i], recs[j] = recs[j], recs[i]
+		})
+	case orderReverse:
+		for i := len(data) - 1; i >= 0; i-- {
+			recs = append(recs, data[i])
+		}
+	case orderInterleaved:
+		half := (len(data) + 1) / 2
+		for i := 0; i < half; i++ {
+			recs = append(recs, data[i])
+			if half+i < len(data) {
+				recs = append(recs, data[half+i])
+			}
+		}
+	default:
+		return data
+	}
+	return recs
+}
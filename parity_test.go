@@ -0,0 +1,225 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/lbe/go-sql-test/gen/model"
+)
+
+// parityLayers are the access layers whose upserts must behave identically
+var parityLayers = []string{"RawSQL", "Jet", "JetPrepared"}
+
+// sentinelTst is written to changed_tst before each upsert so that a fired
+// update trigger can be told apart from a skipped update within one second
+const sentinelTst = "2000-01-01 00:00:00"
+
+func strPtr(s string) *string { return &s }
+func i32Ptr(i int32) *int32   { return &i }
+
+// parityUser returns a fully populated row for user
+func parityUser(user string) model.User {
+	return model.User{
+		User:      user,
+		City:      strPtr("Springfield"),
+		Region:    strPtr("IL"),
+		Country:   strPtr("US"),
+		AreaCode:  strPtr("217"),
+		ZipCode:   strPtr("62701"),
+		YearBirth: i32Ptr(1970),
+		Im:        strPtr("@" + user),
+		Name:      strPtr("Homer"),
+	}
+}
+
+// parityRow is the observable state of a row after an upsert
+type parityRow struct {
+	User, City, Region, Country, AreaCode, ZipCode, Im, Name sql.NullString
+	YearBirth                                                sql.NullInt32
+	Changed                                                  bool
+}
+
+// openParityDB returns an in-memory database holding the benchmark schema
+func openParityDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: is a new database, so keep just one
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	saved := opt.db
+	opt.db = db
+	defer func() { opt.db = saved }()
+	if err := dbCreateSchema(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// upsertWith runs the insert scenario of layer for every rec in steps, resetting
+// changed_tst to sentinelTst before each step, and returns the final table
+func upsertWith(t *testing.T, layer string, steps [][]model.User) []parityRow {
+	t.Helper()
+	db := openParityDB(t)
+	s, ok := lookupScenario(layer, phaseInsert)
+	if !ok {
+		t.Fatalf("no %s insert scenario", layer)
+	}
+	if err := s.Setup(db); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Teardown()
+
+	ex := &executor{db: db}
+	for _, step := range steps {
+		if _, err := db.Exec(`UPDATE user SET changed_tst = ?;`, sentinelTst); err != nil {
+			t.Fatal(err)
+		}
+		for _, rec := range step {
+			if _, err := s.Run(ex, rec); err != nil {
+				t.Fatalf("%s upsert of %s: %v", layer, rec.User, err)
+			}
+		}
+	}
+
+	rows, err := db.Query(`
+		SELECT user, city, region, country, area_code, zip_code, year_birth, im, name,
+		       changed_tst IS NOT ?
+		  FROM user
+		 ORDER BY user;`, sentinelTst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var table []parityRow
+	for rows.Next() {
+		var r parityRow
+		err := rows.Scan(&r.User, &r.City, &r.Region, &r.Country, &r.AreaCode, &r.ZipCode, &r.YearBirth,
+			&r.Im, &r.Name, &r.Changed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		table = append(table, r)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func TestUpsertParity(t *testing.T) {
+	withNulls := parityUser("nulls")
+	withNulls.City, withNulls.Region, withNulls.YearBirth, withNulls.Name = nil, nil, nil, nil
+
+	regionOnly := parityUser("region")
+	regionOnly.Region = strPtr("WI")
+
+	cityOnly := parityUser("city")
+	cityOnly.City = strPtr("Shelbyville")
+
+	toNull := parityUser("to-null")
+	toNull.ZipCode, toNull.Im = nil, nil
+
+	fromNull := parityUser("from-null")
+	fromNull.Country = nil
+
+	yearOnly := parityUser("year")
+	*yearOnly.YearBirth = 1969
+
+	tests := []struct {
+		name  string
+		steps [][]model.User
+		// changed lists whether each row, in user order, was updated by the
+		// last step.  It is left nil for tables of several rows, where
+		// trg_user_update touches every row, and only parity is checked
+		changed []bool
+	}{
+		{
+			name:    "insert with NULLs",
+			steps:   [][]model.User{{withNulls}},
+			changed: []bool{true},
+		},
+		{
+			name:    "unchanged row is not updated",
+			steps:   [][]model.User{{parityUser("same")}, {parityUser("same")}},
+			changed: []bool{false},
+		},
+		{
+			name:    "unchanged row with NULLs is not updated",
+			steps:   [][]model.User{{withNulls}, {withNulls}},
+			changed: []bool{false},
+		},
+		{
+			name:    "only region changes",
+			steps:   [][]model.User{{parityUser("region")}, {regionOnly}},
+			changed: []bool{true},
+		},
+		{
+			name:    "only city changes",
+			steps:   [][]model.User{{parityUser("city")}, {cityOnly}},
+			changed: []bool{true},
+		},
+		{
+			name:    "value to NULL",
+			steps:   [][]model.User{{parityUser("to-null")}, {toNull}},
+			changed: []bool{true},
+		},
+		{
+			name:    "NULL to value",
+			steps:   [][]model.User{{fromNull}, {parityUser("from-null")}},
+			changed: []bool{true},
+		},
+		{
+			name:    "decremented year_birth",
+			steps:   [][]model.User{{parityUser("year")}, {yearOnly}},
+			changed: []bool{true},
+		},
+		{
+			name: "mixed batch",
+			steps: [][]model.User{
+				{parityUser("a"), parityUser("region"), withNulls},
+				{parityUser("a"), regionOnly, withNulls},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tables := map[string][]parityRow{}
+			for _, layer := range parityLayers {
+				tables[layer] = upsertWith(t, layer, tt.steps)
+			}
+
+			want := tables[parityLayers[0]]
+			if tt.changed != nil && len(want) != len(tt.changed) {
+				t.Fatalf("%s: got %d rows, want %d", parityLayers[0], len(want), len(tt.changed))
+			}
+			for i := 0; i < len(tt.changed); i++ {
+				r := want[i]
+				if r.Changed != tt.changed[i] {
+					t.Errorf("%s: user %s changed_tst updated = %t, want %t", parityLayers[0], r.User.String,
+						r.Changed, tt.changed[i])
+				}
+			}
+			for _, layer := range parityLayers[1:] {
+				if got := tables[layer]; !reflect.DeepEqual(got, want) {
+					t.Errorf("%s and %s differ:\n%s\n%s", layer, parityLayers[0], formatRows(got), formatRows(want))
+				}
+			}
+		})
+	}
+}
+
+// formatRows renders a table for failure messages
+func formatRows(rows []parityRow) (s string) {
+	for _, r := range rows {
+		s += fmt.Sprintf("  %+v\n", r)
+	}
+	return
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// driverName is the database/sql driver used for every connection.  It is the
+// mattn/go-sqlite3 driver with a connect hook applying the -pragma settings,
+// as PRAGMAs such as page_size, mmap_size and temp_store cannot be set in the
+// DSN and must be applied to every connection in the pool
+const driverName = "sqlite3_pragma"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			for _, p := range opt.pragmas {
+				if _, err := conn.Exec(p.statement(), nil); err != nil {
+					return fmt.Errorf("%s: %w", p.statement(), err)
+				}
+			}
+			return nil
+		},
+	})
+}
+
+// reportedPragmas are always read back and logged after the database is opened
+var reportedPragmas = []string{
+	"journal_mode", "synchronous", "cache_size", "page_size", "mmap_size", "temp_store", "locking_mode",
+	"busy_timeout", "foreign_keys",
+}
+
+var (
+	pragmaNameRe  = regexp.MustCompile(`^[a-z_]+$`)
+	pragmaValueRe = regexp.MustCompile(`^-?[A-Za-z0-9_]+$`)
+)
+
+// pragma is a single PRAGMA name = value setting
+type pragma struct {
+	Name  string
+	Value string
+}
+
+// statement returns the SQL applying p
+func (p pragma) statement() string {
+	return fmt.Sprintf("PRAGMA %s = %s;", p.Name, p.Value)
+}
+
+// parsePragma parses a "name=value" setting.  Names and values are restricted
+// to plain identifiers and numbers since they are pasted into SQL
+func parsePragma(s string) (p pragma, err error) {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return p, fmt.Errorf("pragma %q is not name=value", s)
+	}
+	p = pragma{strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(value)}
+	if !pragmaNameRe.MatchString(p.Name) {
+		return p, fmt.Errorf("invalid pragma name %q", p.Name)
+	}
+	if !pragmaValueRe.MatchString(p.Value) {
+		return p, fmt.Errorf("invalid value %q for pragma %s", p.Value, p.Name)
+	}
+	return
+}
+
+// pragmaList implements flag.Value for the repeatable -pragma flag.  A later
+// setting of the same name replaces the earlier one
+type pragmaList []pragma
+
+func (l *pragmaList) String() string {
+	if l == nil {
+		return ""
+	}
+	s := make([]string, len(*l))
+	for i, p := range *l {
+		s[i] = p.Name + "=" + p.Value
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *pragmaList) Set(s string) error {
+	p, err := parsePragma(s)
+	if err != nil {
+		return err
+	}
+	for i := range *l {
+		if (*l)[i].Name == p.Name {
+			(*l)[i] = p
+			return nil
+		}
+	}
+	*l = append(*l, p)
+	return nil
+}
+
+// readPragmas queries the values in force for the reported PRAGMAs and for
+// any set with -pragma
+func readPragmas(db *sql.DB) (effective map[string]string, err error) {
+	names := append([]string{}, reportedPragmas...)
+	for _, p := range opt.pragmas {
+		if !slices.Contains(names, p.Name) {
+			names = append(names, p.Name)
+		}
+	}
+
+	effective = map[string]string{}
+	for _, name := range names {
+		var value sql.NullString
+		err = db.QueryRow("PRAGMA " + name + ";").Scan(&value)
+		if err == sql.ErrNoRows {
+			// write-only pragmas such as optimize return nothing
+			err = nil
+			continue
+		}
+		if err != nil {
+			return effective, fmt.Errorf("PRAGMA %s: %w", name, err)
+		}
+		effective[name] = value.String
+	}
+	return
+}
+
+// pragmaKeywords maps the symbolic values of PRAGMAs to the numbers they are
+// reported back as
+var pragmaKeywords = map[string]map[string]string{
+	"synchronous":  {"off": "0", "normal": "1", "full": "2", "extra": "3"},
+	"temp_store":   {"default": "0", "file": "1", "memory": "2"},
+	"foreign_keys": {"off": "0", "false": "0", "no": "0", "on": "1", "true": "1", "yes": "1"},
+}
+
+// normalizePragmaValue returns value in the form in which PRAGMA name reports it
+func normalizePragmaValue(name, value string) string {
+	value = strings.ToLower(value)
+	if n, ok := pragmaKeywords[name][value]; ok {
+		return n
+	}
+	return value
+}
+
+// logPragmas logs the effective PRAGMA values in name order and warns about
+// any -pragma setting that did not take effect
+func logPragmas(effective map[string]string) {
+	log.Printf("Effective pragmas: %s", formatPragmas(effective))
+	for _, p := range opt.pragmas {
+		got, ok := effective[p.Name]
+		if ok && normalizePragmaValue(p.Name, got) != normalizePragmaValue(p.Name, p.Value) {
+			log.Printf("[warning] pragma %s=%s requested but %s is in effect", p.Name, p.Value, got)
+		}
+	}
+}
+
+// formatPragmas renders effective as "name=value" pairs sorted by name
+func formatPragmas(effective map[string]string) string {
+	names := make([]string, 0, len(effective))
+	for name := range effective {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = name + "=" + effective[name]
+	}
+	return strings.Join(names, ";")
+}
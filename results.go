@@ -0,0 +1,278 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// RunConfig describes the settings a Result was measured with
+type RunConfig struct {
+	Driver         string            `json:"driver"`
+	SQLiteVersion  string            `json:"sqlite_version"`
+	DBPath         string            `json:"db_path"`
+	DSNOptions     string            `json:"dsn_options"`
+	Pragmas        map[string]string `json:"pragmas"`
+	RowCount       int               `json:"row_count"`
+	UpdateCount    int               `json:"update_count"`
+	BatchSize      int               `json:"batch_size"`
+	CommitEvery    int               `json:"commit_every"`
+	Workers        int               `json:"workers"`
+	MaxOpenConns   int               `json:"max_open_conns"`
+	SplitPools     bool              `json:"split_pools"`
+	ReaderConns    int               `json:"reader_conns"`
+	Mixed          bool              `json:"mixed"`
+	Workload       string            `json:"workload,omitempty"`
+	WorkloadOps    int               `json:"workload_ops,omitempty"`
+	WorkloadTime   time.Duration     `json:"workload_duration_ns,omitempty"`
+	Order          string            `json:"order"`
+	KeyDist        string            `json:"key_dist"`
+	Skew           float64           `json:"skew"`
+	HotSet         float64           `json:"hot_set"`
+	HotOps         float64           `json:"hot_ops"`
+	GroupSize      int               `json:"group_size"`
+	GroupWait      time.Duration     `json:"group_wait_ns"`
+	UseTransaction bool              `json:"use_transaction"`
+	Sweep          string            `json:"sweep,omitempty"`
+}
+
+// currentRunConfig captures the run configuration from the command line flags
+func currentRunConfig() RunConfig {
+	libVersion, _, _ := sqlite3.Version()
+	dsnOptions := ""
+	if i := strings.IndexByte(opt.dsn, '?'); i >= 0 {
+		dsnOptions = opt.dsn[i+1:]
+	}
+	return RunConfig{
+		Driver:         "sqlite3",
+		SQLiteVersion:  libVersion,
+		DBPath:         *opt.dbPath,
+		DSNOptions:     dsnOptions,
+		Pragmas:        opt.effectivePragmas,
+		RowCount:       *opt.rowCount,
+		UpdateCount:    *opt.updateCount,
+		BatchSize:      *opt.batchSize,
+		CommitEvery:    *opt.commitEvery,
+		Workers:        *opt.workers,
+		MaxOpenConns:   writerConns(),
+		SplitPools:     *opt.splitPools,
+		ReaderConns:    *opt.readerConns,
+		Mixed:          *opt.mixed,
+		Workload:       opt.workload.String(),
+		WorkloadOps:    *opt.workloadOps,
+		WorkloadTime:   *opt.workloadDuration,
+		Order:          *opt.order,
+		KeyDist:        phaseKeyDist(),
+		Skew:           *opt.skew,
+		HotSet:         *opt.hotSet,
+		HotOps:         *opt.hotOps,
+		GroupSize:      *opt.groupSize,
+		GroupWait:      *opt.groupWait,
+		UseTransaction: useTx(),
+		Sweep:          opt.sweepLabel,
+	}
+}
+
+// Result holds the measurements of a single scenario phase.  Ops counts the
+// records processed while Statements counts the operations executed, which
+// differ for a BatchScenario; Latency is recorded per statement.  Misses
+// counts the reads of -mixed that did not find their row yet.  Commits and
+// CommitLatency cover the transactions committed by the runner.  Busy and
+// Locked count the Errors that were SQLITE_BUSY and SQLITE_LOCKED, PoolWaits
+// and PoolWaitTime the waits for a free connection of the database/sql pool
+type Result struct {
+	Scenario      string        `json:"scenario"`
+	Phase         string        `json:"phase"`
+	Iteration     int           `json:"iteration"`
+	Start         time.Time     `json:"start"`
+	End           time.Time     `json:"end"`
+	Ops           int64         `json:"ops"`
+	Statements    int64         `json:"statements"`
+	RowsAffected  int64         `json:"rows_affected"`
+	Errors        int64         `json:"errors"`
+	Misses        int64         `json:"misses"`
+	Busy          int64         `json:"busy"`
+	Locked        int64         `json:"locked"`
+	PoolWaits     int64         `json:"pool_waits"`
+	PoolWaitTime  time.Duration `json:"pool_wait_ns"`
+	Commits       int64         `json:"commits"`
+	Bytes         uint64        `json:"bytes_allocated"`
+	Allocs        uint64        `json:"allocs"`
+	Verified      bool          `json:"verified"`
+	Mismatches    int64         `json:"mismatches"`
+	Latency       *Histogram    `json:"latency"`
+	CommitLatency *Histogram    `json:"commit_latency,omitempty"`
+	Stages        []Stage       `json:"stages,omitempty"`
+	Config        RunConfig     `json:"config"`
+}
+
+// Stage is the latency of one step of every operation of a phase, as
+// recorded by executor.stage
+type Stage struct {
+	Name    string     `json:"name"`
+	Latency *Histogram `json:"latency"`
+}
+
+// Duration returns the wall time of the phase
+func (r Result) Duration() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+// OpsPerSec returns the throughput of the phase in operations per second
+func (r Result) OpsPerSec() float64 {
+	d := r.Duration().Seconds()
+	if d <= 0 {
+		return 0
+	}
+	return float64(r.Ops) / d
+}
+
+// countError counts err as a failed operation, classifying SQLite's busy
+// and locked errors
+func (r *Result) countError(err error) {
+	r.Errors++
+	var e sqlite3.Error
+	if errors.As(err, &e) {
+		switch e.Code {
+		case sqlite3.ErrBusy:
+			r.Busy++
+		case sqlite3.ErrLocked:
+			r.Locked++
+		}
+	}
+}
+
+// add accumulates the counters, latencies and stages of p, the partial result
+// of one worker, into r
+func (r *Result) add(p Result) {
+	r.Ops += p.Ops
+	r.Statements += p.Statements
+	r.RowsAffected += p.RowsAffected
+	r.Errors += p.Errors
+	r.Misses += p.Misses
+	r.Busy += p.Busy
+	r.Locked += p.Locked
+	r.Commits += p.Commits
+	r.Latency.Merge(p.Latency)
+	if p.CommitLatency != nil {
+		if r.CommitLatency == nil {
+			r.CommitLatency = NewHistogram()
+		}
+		r.CommitLatency.Merge(p.CommitLatency)
+	}
+	for _, st := range p.Stages {
+		i := slices.IndexFunc(r.Stages, func(s Stage) bool { return s.Name == st.Name })
+		if i < 0 {
+			r.Stages = append(r.Stages, Stage{Name: st.Name, Latency: NewHistogram()})
+			i = len(r.Stages) - 1
+		}
+		r.Stages[i].Latency.Merge(st.Latency)
+	}
+}
+
+// rowsAffected converts the return values of an Exec into those of Scenario.Run
+func rowsAffected(res sql.Result, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// printSummary writes a table of results to w
+func printSummary(w io.Writer, results []Result) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Scenario\tPhase\tIter\tOps\tRows\tErrors\tWall time\tOps/sec\tp50\tp90\tp99\tp99.9\tMax\t")
+	for _, r := range results {
+		lat := r.Latency
+		if lat == nil {
+			lat = NewHistogram()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%s\t%.0f\t%s\t%s\t%s\t%s\t%s\t\n", r.Scenario, r.Phase, r.Iteration, r.Ops,
+			r.RowsAffected, r.Errors, r.Duration().Round(time.Millisecond), r.OpsPerSec(),
+			roundLatency(lat.Percentile(50)), roundLatency(lat.Percentile(90)), roundLatency(lat.Percentile(99)),
+			roundLatency(lat.Percentile(99.9)), roundLatency(lat.Max()))
+	}
+	tw.Flush()
+}
+
+// roundLatency trims a latency to three significant digits for display
+func roundLatency(d time.Duration) time.Duration {
+	for m := time.Duration(1); m < time.Hour; m *= 10 {
+		if d < 1000*m {
+			return d.Round(m)
+		}
+	}
+	return d
+}
+
+// printStages writes the per-stage breakdown of every phase that recorded
+// stages to w, merging iterations.  Share is the stage's part of the summed
+// mean latency of all stages
+func printStages(w io.Writer, results []Result) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	header := false
+	for _, g := range groupResults(results) {
+		var names []string
+		merged := map[string]*Histogram{}
+		for _, r := range g.Results {
+			for _, st := range r.Stages {
+				if _, ok := merged[st.Name]; !ok {
+					names = append(names, st.Name)
+					merged[st.Name] = NewHistogram()
+				}
+				merged[st.Name].Merge(st.Latency)
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		if !header {
+			fmt.Fprintln(w)
+			fmt.Fprintln(tw, "Scenario\tPhase\tStage\tMean\tp50\tp99\tShare\t")
+			header = true
+		}
+
+		var total time.Duration
+		for _, name := range names {
+			total += merged[name].Mean()
+		}
+		for _, name := range names {
+			h := merged[name]
+			share := 0.0
+			if total > 0 {
+				share = float64(h.Mean()) / float64(total) * 100
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%.1f%%\t\n", g.Scenario, g.Phase, name,
+				roundLatency(h.Mean()), roundLatency(h.Percentile(50)), roundLatency(h.Percentile(99)), share)
+		}
+	}
+	tw.Flush()
+}
+
+// printContention writes the error, miss, busy, locked and pool wait counts of
+// every phase run with more than one worker to w
+func printContention(w io.Writer, results []Result) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	header := false
+	for _, r := range results {
+		if r.Config.Workers <= 1 {
+			continue
+		}
+		if !header {
+			fmt.Fprintln(w)
+			fmt.Fprintln(tw, "Settings\tScenario\tPhase\tIter\tWorkers\tOps/sec\tErrors\tMisses\tBusy\tLocked\tPool waits\tPool wait\t")
+			header = true
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%.0f\t%d\t%d\t%d\t%d\t%d\t%s\t\n", r.Config.Sweep, r.Scenario, r.Phase,
+			r.Iteration, r.Config.Workers, r.OpsPerSec(), r.Errors, r.Misses, r.Busy, r.Locked, r.PoolWaits,
+			roundLatency(r.PoolWaitTime))
+	}
+	tw.Flush()
+}
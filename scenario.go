@@ -0,0 +1,510 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jet/jet/v2/qrm"
+	"github.com/schollz/progressbar/v3"
+
+	"github.com/lbe/go-sql-test/gen/model"
+)
+
+// phases in the order in which they are run for each access layer
+const (
+	phaseInsert = "insert"
+	phaseUpdate = "update"
+	phaseSelect = "select"
+)
+
+var phases = []string{phaseInsert, phaseUpdate, phaseSelect}
+
+// Scenario is a single benchmark phase (insert, update or select) for one
+// database access layer (RawSQL, Jet, ...).  The runner takes care of
+// transactions, progress reporting and timing so that a Scenario only has to
+// perform one operation per call to Run
+type Scenario interface {
+	// Name returns the access layer exercised by the scenario, e.g. "RawSQL"
+	Name() string
+	// Phase returns the workload performed by the scenario, e.g. "insert"
+	Phase() string
+	// Setup prepares anything Run needs, such as prepared statements
+	Setup(db *sql.DB) error
+	// Run performs a single operation for rec and returns the number of
+	// rows it affected (or read, for a select)
+	Run(ex *executor, rec model.User) (int64, error)
+	// Teardown releases whatever was acquired by Setup
+	Teardown() error
+}
+
+// BatchScenario is implemented by scenarios that write several records with
+// one statement.  The runner hands RunBatch up to -batchSize records at a time
+// instead of calling Run for each of them
+type BatchScenario interface {
+	Scenario
+	// RunBatch performs a single operation for all of recs and returns the
+	// number of rows it affected
+	RunBatch(ex *executor, recs []model.User) (int64, error)
+}
+
+// TxReleaser is implemented by scenarios holding resources bound to the
+// runner's transaction, such as cached statements.  With -commitEvery the
+// runner calls ReleaseTx after each commit so that they do not pile up
+type TxReleaser interface {
+	ReleaseTx(tx *sql.Tx)
+}
+
+// TxManager is implemented by scenarios that begin and commit transactions of
+// their own.  The runner does not wrap them in its transactions, so that
+// -useTransaction and -commitEvery do not apply to them
+type TxManager interface {
+	ManagesTx() bool
+}
+
+// managesTx reports whether s manages its own transactions
+func managesTx(s Scenario) bool {
+	m, ok := s.(TxManager)
+	return ok && m.ManagesTx()
+}
+
+// registry of all known scenarios in registration order
+var scenarios []Scenario
+
+// registerScenario adds s to the registry.  It is meant to be called from init()
+func registerScenario(s Scenario) {
+	for _, r := range scenarios {
+		if r.Name() == s.Name() && r.Phase() == s.Phase() {
+			log.Fatalf("[error] scenario %s %s registered twice", s.Name(), s.Phase())
+		}
+	}
+	scenarios = append(scenarios, s)
+}
+
+// lookupScenario returns the registered scenario for name and phase
+func lookupScenario(name, phase string) (Scenario, bool) {
+	for _, s := range scenarios {
+		if s.Name() == name && s.Phase() == phase {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// cloneScenario returns a new instance of the registered scenario s with the
+// same configuration, which can be set up on a pool of its own
+func cloneScenario(s Scenario) Scenario {
+	v := reflect.New(reflect.TypeOf(s).Elem())
+	v.Elem().Set(reflect.ValueOf(s).Elem())
+	return v.Interface().(Scenario)
+}
+
+// executor is handed to Scenario.Run and gives access to either the database
+// or the transaction opened by the runner
+type executor struct {
+	db *sql.DB
+	tx *sql.Tx
+
+	// context of the current operation, see begin
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// per-stage latencies of the current phase, see stage
+	stages    []Stage
+	stageMark time.Time
+}
+
+// begin marks the start of an operation for the timing of its stages and
+// creates its context, which carries a deadline when -queryTimeout is set
+func (e *executor) begin() {
+	e.ctx, e.cancel = context.Background(), nil
+	if *opt.queryTimeout > 0 {
+		e.ctx, e.cancel = context.WithTimeout(e.ctx, *opt.queryTimeout)
+	}
+	e.stageMark = time.Now()
+}
+
+// end releases the context of the current operation
+func (e *executor) end() {
+	if e.cancel != nil {
+		e.cancel()
+	}
+}
+
+// context returns the context scenarios pass to ExecContext/QueryContext
+func (e *executor) context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+// stage records the time since the previous stage, or since the start of the
+// operation, as the latency of stage name.  Scenarios call it after each step
+// of an operation (e.g. build, serialize, exec) to break down its cost
+func (e *executor) stage(name string) {
+	now := time.Now()
+	d := now.Sub(e.stageMark)
+	e.stageMark = now
+	for i := range e.stages {
+		if e.stages[i].Name == name {
+			e.stages[i].Latency.Record(d)
+			return
+		}
+	}
+	h := NewHistogram()
+	h.Record(d)
+	e.stages = append(e.stages, Stage{Name: name, Latency: h})
+}
+
+// stmt returns s bound to the runner's transaction when one is open
+func (e *executor) stmt(s *sql.Stmt) *sql.Stmt {
+	if e.tx != nil {
+		return e.tx.Stmt(s)
+	}
+	return s
+}
+
+// conn returns the runner's transaction when one is open, else the database
+func (e *executor) conn() qrm.DB {
+	if e.tx != nil {
+		return e.tx
+	}
+	return e.db
+}
+
+// useTx reports whether the runner wraps the work in transactions
+func useTx() bool {
+	return *opt.useTransaction || *opt.commitEvery > 0
+}
+
+// commit commits the runner's transaction, recording its latency in res, and
+// lets s release whatever it bound to the transaction
+func (e *executor) commit(s Scenario, res *Result) error {
+	start := time.Now()
+	err := e.tx.Commit()
+	if res.CommitLatency == nil {
+		res.CommitLatency = NewHistogram()
+	}
+	res.CommitLatency.Record(time.Since(start))
+	if err == nil {
+		res.Commits++
+	}
+	if r, ok := s.(TxReleaser); ok {
+		r.ReleaseTx(e.tx)
+	}
+	e.tx = nil
+	return err
+}
+
+// workloadSize returns the number of records a phase operates upon, which
+// is -updateCount at most for the updates and every row for the others
+func workloadSize(phase string, data []model.User) int {
+	if phase == phaseUpdate {
+		return min(*opt.updateCount, len(data))
+	}
+	return len(data)
+}
+
+// workload returns the records a phase operates upon, the first workloadSize
+// rows of data.  The inserts take them in the -order order.  With -keyDist
+// the updates and selects draw as many rows from all of data instead
+func workload(phase string, data []model.User) []model.User {
+	n := workloadSize(phase, data)
+	if phase == phaseInsert {
+		return insertOrder(data[:n])
+	}
+	if drawsKeys(phase) && n > 0 {
+		return drawKeys(data, n)
+	}
+	return data[:n]
+}
+
+// poolFor returns the connection pool the scenarios of phase run on, which is
+// the read-only pool of -splitPools for the selects
+func poolFor(phase string) *sql.DB {
+	if opt.readDB != nil && strings.HasPrefix(phase, phaseSelect) {
+		return opt.readDB
+	}
+	return opt.db
+}
+
+// runScenario executes s against every record of its workload, split across
+// -workers goroutines sharing the pool of poolFor, wrapping the work in a transaction when
+// requested by the useTransaction flag, or in one transaction per
+// -commitEvery records.  Failed operations are counted in the Result rather
+// than aborting the phase
+func runScenario(s Scenario, data []model.User) (res Result, err error) {
+	res = Result{Scenario: s.Name(), Phase: s.Phase(), Latency: NewHistogram(), Config: currentRunConfig()}
+	recs := workload(s.Phase(), data)
+	if len(recs) == 0 {
+		return
+	}
+	log.Printf("Executing %s %s", s.Name(), s.Phase())
+
+	db := poolFor(s.Phase())
+	if err = s.Setup(db); err != nil {
+		return res, fmt.Errorf("%s %s setup: %w", s.Name(), s.Phase(), err)
+	}
+	defer func() {
+		if err2 := s.Teardown(); err2 != nil && err == nil {
+			err = fmt.Errorf("%s %s teardown: %w", s.Name(), s.Phase(), err2)
+		}
+	}()
+
+	var memBefore, memAfter runtime.MemStats
+	runtime.ReadMemStats(&memBefore)
+	poolBefore := db.Stats()
+	res.Start = time.Now()
+
+	workers := min(*opt.workers, len(recs))
+	parts := make([]Result, workers)
+	errs := make([]error, workers)
+	bar := progressbar.Default(int64(len(recs)))
+	var wg sync.WaitGroup
+	work := splitWork(s.Phase(), recs, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			parts[w].Latency = NewHistogram()
+			errs[w] = runWorker(s, work[w], &parts[w], bar)
+		}(w)
+	}
+	wg.Wait()
+	bar.Finish()
+
+	runtime.ReadMemStats(&memAfter)
+	poolAfter := db.Stats()
+	res.Bytes = memAfter.TotalAlloc - memBefore.TotalAlloc
+	res.Allocs = memAfter.Mallocs - memBefore.Mallocs
+	res.PoolWaits = poolAfter.WaitCount - poolBefore.WaitCount
+	res.PoolWaitTime = poolAfter.WaitDuration - poolBefore.WaitDuration
+	for _, p := range parts {
+		res.add(p)
+	}
+	if res.Commits > 1 {
+		log.Printf("Committed %d transactions, mean commit %s", res.Commits, roundLatency(res.CommitLatency.Mean()))
+	}
+	if res.Misses > 0 {
+		log.Printf("%d of %d reads found no row", res.Misses, res.Ops)
+	}
+	if workers > 1 {
+		log.Printf("%d workers: %d busy, %d locked, %d pool waits (%s), %d connections open", workers, res.Busy,
+			res.Locked, res.PoolWaits, roundLatency(res.PoolWaitTime), poolAfter.OpenConnections)
+	}
+	res.End = time.Now()
+	return res, errors.Join(errs...)
+}
+
+// runWorker executes s against recs on its own executor, recording the
+// measurements in res and advancing bar
+func runWorker(s Scenario, recs []model.User, res *Result, bar *progressbar.ProgressBar) (err error) {
+	ex := &executor{db: poolFor(s.Phase())}
+	// Defer a rollback in case anything fails.
+	defer func() {
+		if ex.tx != nil {
+			ex.tx.Rollback()
+		}
+	}()
+
+	batchSize := 1
+	bs, isBatch := s.(BatchScenario)
+	if isBatch {
+		batchSize = *opt.batchSize
+	}
+
+	inTx := useTx() && !managesTx(s)
+	uncommitted := 0
+	for i := 0; i < len(recs); i += batchSize {
+		batch := recs[i:min(i+batchSize, len(recs))]
+		if inTx && ex.tx == nil {
+			// Get a Tx for making transaction requests.
+			if ex.tx, err = ex.db.Begin(); err != nil {
+				return
+			}
+		}
+		opStart := time.Now()
+		ex.begin()
+		var n int64
+		var runErr error
+		if isBatch {
+			n, runErr = bs.RunBatch(ex, batch)
+		} else {
+			n, runErr = s.Run(ex, batch[0])
+		}
+		ex.end()
+		res.Latency.Record(time.Since(opStart))
+		res.Ops += int64(len(batch))
+		res.Statements++
+		res.RowsAffected += n
+		switch {
+		case errors.Is(runErr, errMiss):
+			res.Misses++
+		case runErr != nil:
+			res.countError(runErr)
+			log.Printf("[warning] %s %s user = %s: %v", s.Name(), s.Phase(), batch[0].User, runErr)
+		}
+		bar.Add(len(batch))
+
+		uncommitted += len(batch)
+		if inTx && *opt.commitEvery > 0 && uncommitted >= *opt.commitEvery {
+			if err := ex.commit(s, res); err != nil {
+				res.countError(err)
+				log.Printf("[warning] %s %s commit: %v", s.Name(), s.Phase(), err)
+			}
+			uncommitted = 0
+		}
+	}
+	res.Stages = ex.stages
+
+	if ex.tx != nil {
+		log.Print("Commit Start")
+		if err := ex.commit(s, res); err != nil {
+			res.countError(err)
+			log.Printf("[warning] %s %s commit: %v", s.Name(), s.Phase(), err)
+		}
+		log.Print("Commit Finished")
+	}
+	return nil
+}
+
+// runSuite runs every phase registered for the access layer name.  Suites
+// without an insert phase, such as the update strategies, find the table
+// loaded by loadData.  With -workload the suite runs the mixed workload of
+// runWorkload instead of its phases.  With -mixed the select scenario of mixedReader runs
+// alongside each write phase instead of on its own
+func runSuite(name string, data []model.User) (results []Result, err error) {
+	if _, ok := lookupScenario(name, phaseInsert); !ok {
+		if err = loadData(data); err != nil {
+			return
+		}
+	}
+	if len(opt.workload) > 0 {
+		return runWorkload(name, data)
+	}
+	read, mixed := mixedReader(name)
+	mixed = mixed && *opt.mixed
+	for _, phase := range phases {
+		s, ok := lookupScenario(name, phase)
+		if !ok || workloadSize(phase, data) == 0 || mixed && phase == phaseSelect {
+			continue
+		}
+		var res, readRes Result
+		if mixed {
+			res, readRes, err = runMixed(s, read, data)
+		} else {
+			res, err = runScenario(s, data)
+		}
+		if err != nil {
+			return results, err
+		}
+		if *opt.verify && phase != phaseSelect {
+			if err = verifyPhase(&res, data); err != nil {
+				return results, err
+			}
+		}
+		results = append(results, res)
+		if mixed {
+			results = append(results, readRes)
+		}
+	}
+	return
+}
+
+// loadData fills the table with data using the RawSQL insert scenario,
+// discarding its measurements
+func loadData(data []model.User) error {
+	log.Print("Loading data")
+	s, _ := lookupScenario("RawSQL", phaseInsert)
+	_, err := runScenario(s, data)
+	return err
+}
+
+// runIterations runs the suite for name -warmup times, discarding the
+// results, followed by -iterations measured runs.  The database is reset with
+// dbCleanUp before every run except the first, which is only reset when
+// resetFirst is set
+func runIterations(name string, data []model.User, resetFirst bool) (results []Result, err error) {
+	runs := *opt.warmup + *opt.iterations
+	for i := 0; i < runs; i++ {
+		if i > 0 || resetFirst {
+			if err = dbCleanUp(); err != nil {
+				return
+			}
+			log.Printf("Reset database for %s", name)
+		}
+		if i < *opt.warmup {
+			log.Printf("Warmup %d of %d for %s", i+1, *opt.warmup, name)
+		} else if *opt.iterations > 1 {
+			log.Printf("Iteration %d of %d for %s", i-*opt.warmup+1, *opt.iterations, name)
+		}
+
+		res, err := runSuite(name, data)
+		if err != nil {
+			return results, err
+		}
+		if i < *opt.warmup {
+			continue
+		}
+		for j := range res {
+			res[j].Iteration = i - *opt.warmup + 1
+		}
+		results = append(results, res...)
+	}
+	return
+}
+
+// selectedSuites returns the access layers chosen on the command line
+func selectedSuites() (names []string) {
+	if *opt.useRawSQL {
+		names = append(names, "RawSQL")
+	}
+	if *opt.useJet {
+		names = append(names, "Jet")
+	}
+	if *opt.useJetPrepared {
+		names = append(names, "JetPrepared")
+	}
+	layers := names
+	if *opt.useBatch {
+		for _, name := range layers {
+			names = append(names, name+"Batch")
+		}
+	}
+	if *opt.useGroupCommit {
+		for _, layer := range layers {
+			if layer == "RawSQL" || layer == "Jet" {
+				names = append(names, layer+"GroupCommit")
+			}
+		}
+	}
+	for _, strategy := range opt.updateStrategies {
+		for _, layer := range layers {
+			if layer == "RawSQL" || layer == "Jet" {
+				names = append(names, strategySuite(layer, strategy))
+			}
+		}
+	}
+	return
+}
+
+// runSuites runs every selected access layer, resetting the database between
+// them so that each one starts from an empty table
+func runSuites(data []model.User) (results []Result, err error) {
+	for i, name := range selectedSuites() {
+		res, err := runIterations(name, data, i > 0)
+		results = append(results, res...)
+		if err != nil {
+			return results, err
+		}
+	}
+	return
+}
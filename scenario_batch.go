@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+
+	"github.com/lbe/go-sql-test/gen/model"
+	"github.com/lbe/go-sql-test/models"
+)
+
+// maxBatchSize is the largest -batchSize whose multi-row upsert stays within
+// SQLite's default limit of 32766 bound parameters at 9 parameters per row
+const maxBatchSize = 32766 / 9
+
+// upsertUsersSQL returns the raw SQL upsert of n rows, without the IS NOT
+// guard when opt.noUpsertGuard is set
+func upsertUsersSQL(n int) string {
+	if opt.noUpsertGuard {
+		return models.SqlUpsertUsersNoGuard(n)
+	}
+	return models.SqlUpsertUsers(n)
+}
+
+func init() {
+	registerScenario(&rawSQLBatchInsert{})
+	registerScenario(&jetBatchInsert{})
+	registerScenario(&jetPreparedBatchInsert{})
+}
+
+// rawSQLBatchInsert upserts -batchSize rows per statement with
+// models.SqlUpsertUsers.  The statement for a full batch is prepared by Setup.
+// A shorter remainder is prepared when it is seen, or executed unprepared in
+// the runner's transaction, which may hold the only connection of the pool
+type rawSQLBatchInsert struct {
+	db    *sql.DB
+	mu    sync.Mutex
+	stmts map[int]*sql.Stmt
+}
+
+func (s *rawSQLBatchInsert) Name() string  { return "RawSQLBatch" }
+func (s *rawSQLBatchInsert) Phase() string { return phaseInsert }
+
+func (s *rawSQLBatchInsert) Setup(db *sql.DB) error {
+	s.db = db
+	s.stmts = map[int]*sql.Stmt{}
+	stmt, err := db.Prepare(upsertUsersSQL(*opt.batchSize))
+	if err != nil {
+		return err
+	}
+	s.stmts[*opt.batchSize] = stmt
+	return nil
+}
+
+func (s *rawSQLBatchInsert) Run(ex *executor, rec model.User) (int64, error) {
+	return s.RunBatch(ex, []model.User{rec})
+}
+
+func (s *rawSQLBatchInsert) RunBatch(ex *executor, recs []model.User) (int64, error) {
+	args := make([]interface{}, 0, 9*len(recs))
+	for _, rec := range recs {
+		args = append(args, upsertArgs(rec)...)
+	}
+
+	s.mu.Lock()
+	stmt, ok := s.stmts[len(recs)]
+	if !ok && ex.tx != nil {
+		s.mu.Unlock()
+		return rowsAffected(ex.tx.ExecContext(ex.context(), upsertUsersSQL(len(recs)), args...))
+	}
+	if !ok {
+		var err error
+		if stmt, err = s.db.Prepare(upsertUsersSQL(len(recs))); err != nil {
+			s.mu.Unlock()
+			return 0, err
+		}
+		s.stmts[len(recs)] = stmt
+	}
+	s.mu.Unlock()
+
+	res, err := ex.stmt(stmt).ExecContext(ex.context(), args...)
+	return rowsAffected(res, err)
+}
+
+func (s *rawSQLBatchInsert) Teardown() (err error) {
+	for _, stmt := range s.stmts {
+		err = errors.Join(err, stmt.Close())
+	}
+	return
+}
+
+// jetBatchInsert executes a single jetUpsertUsers statement per batch
+type jetBatchInsert struct{}
+
+func (s *jetBatchInsert) Name() string           { return "JetBatch" }
+func (s *jetBatchInsert) Phase() string          { return phaseInsert }
+func (s *jetBatchInsert) Setup(db *sql.DB) error { return nil }
+func (s *jetBatchInsert) Teardown() error        { return nil }
+
+func (s *jetBatchInsert) Run(ex *executor, rec model.User) (int64, error) {
+	return s.RunBatch(ex, []model.User{rec})
+}
+
+func (s *jetBatchInsert) RunBatch(ex *executor, recs []model.User) (int64, error) {
+	return execJetStaged(ex, ex.conn(), jetUpsertUsers(recs))
+}
+
+// jetPreparedBatchInsert executes the jetUpsertUsers statement through the
+// statement cache
+type jetPreparedBatchInsert struct {
+	jetPrepared
+}
+
+func (s *jetPreparedBatchInsert) Name() string  { return "JetPreparedBatch" }
+func (s *jetPreparedBatchInsert) Phase() string { return phaseInsert }
+
+func (s *jetPreparedBatchInsert) Run(ex *executor, rec model.User) (int64, error) {
+	return s.RunBatch(ex, []model.User{rec})
+}
+
+func (s *jetPreparedBatchInsert) RunBatch(ex *executor, recs []model.User) (int64, error) {
+	return execJetStaged(ex, s.conn(ex), jetUpsertUsers(recs))
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/lbe/go-sql-test/gen/model"
+	"github.com/lbe/go-sql-test/models"
+)
+
+func init() {
+	registerScenario(&groupCommit{layer: "RawSQL", phase: phaseInsert})
+	registerScenario(&groupCommit{layer: "RawSQL", phase: phaseUpdate})
+	registerScenario(&groupCommit{layer: "Jet", phase: phaseInsert})
+	registerScenario(&groupCommit{layer: "Jet", phase: phaseUpdate})
+}
+
+// groupRequest is one write submitted to a groupWriter
+type groupRequest struct {
+	exec func(tx *sql.Tx) (int64, error)
+	done chan groupReply
+}
+
+// groupReply is the outcome of a groupRequest, sent once its group committed
+type groupReply struct {
+	n   int64
+	err error
+}
+
+// groupWriter is the single writer of the group commit scenarios.  It drains
+// the requests of any number of producers, executes them in one transaction
+// and commits once -groupSize requests are in the transaction or -groupWait
+// has passed since the first of them, before replying to every producer
+type groupWriter struct {
+	db       *sql.DB
+	reqs     chan groupRequest
+	stopped  chan struct{}
+	groups   int64
+	requests int64
+}
+
+// newGroupWriter starts a groupWriter on db
+func newGroupWriter(db *sql.DB) *groupWriter {
+	w := &groupWriter{db: db, reqs: make(chan groupRequest), stopped: make(chan struct{})}
+	go w.run()
+	return w
+}
+
+func (w *groupWriter) run() {
+	defer close(w.stopped)
+	for first := range w.reqs {
+		tx, err := w.db.Begin()
+		if err != nil {
+			first.done <- groupReply{err: err}
+			continue
+		}
+
+		group := []groupRequest{first}
+		replies := []groupReply{w.exec(tx, first)}
+		timer := time.NewTimer(*opt.groupWait)
+	collect:
+		for len(group) < *opt.groupSize {
+			select {
+			case r, ok := <-w.reqs:
+				if !ok {
+					break collect
+				}
+				group = append(group, r)
+				replies = append(replies, w.exec(tx, r))
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		err = tx.Commit()
+		w.groups++
+		w.requests += int64(len(group))
+		for i, r := range group {
+			if err != nil {
+				replies[i] = groupReply{err: err}
+			}
+			r.done <- replies[i]
+		}
+	}
+}
+
+// exec runs r in tx
+func (w *groupWriter) exec(tx *sql.Tx, r groupRequest) groupReply {
+	n, err := r.exec(tx)
+	return groupReply{n, err}
+}
+
+// submit hands exec to the writer and waits until the group holding it has
+// been committed.  ctx only bounds the wait for the writer to accept it
+func (w *groupWriter) submit(ctx context.Context, exec func(tx *sql.Tx) (int64, error)) (int64, error) {
+	done := make(chan groupReply, 1)
+	select {
+	case w.reqs <- groupRequest{exec, done}:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	r := <-done
+	return r.n, r.err
+}
+
+// close stops the writer once the submitted requests have been committed
+func (w *groupWriter) close() {
+	close(w.reqs)
+	<-w.stopped
+}
+
+// groupCommit upserts every row through a groupWriter.  The runner's -workers
+// goroutines are the producers, so the latency recorded per operation is the
+// time a request waits for its group to commit.  The writer manages its own
+// transactions, so the runner does not wrap the producers in any and
+// -useTransaction and -commitEvery do not apply
+type groupCommit struct {
+	layer  string
+	phase  string
+	writer *groupWriter
+	upsert func() *sql.Stmt
+}
+
+func (s *groupCommit) Name() string    { return s.layer + "GroupCommit" }
+func (s *groupCommit) Phase() string   { return s.phase }
+func (s *groupCommit) ManagesTx() bool { return true }
+
+func (s *groupCommit) Setup(db *sql.DB) error {
+	if s.layer == "RawSQL" {
+		s.upsert = models.StmtUpsertUser(db)
+	}
+	s.writer = newGroupWriter(db)
+	return nil
+}
+
+func (s *groupCommit) Run(ex *executor, rec model.User) (int64, error) {
+	if s.phase == phaseUpdate {
+		*rec.YearBirth--
+	}
+	return s.writer.submit(ex.context(), func(tx *sql.Tx) (int64, error) {
+		if s.upsert != nil {
+			return rowsAffected(tx.Stmt(s.upsert()).Exec(upsertArgs(rec)...))
+		}
+		return rowsAffected(jetUpsertUser(rec).Exec(tx))
+	})
+}
+
+func (s *groupCommit) Teardown() error {
+	s.writer.close()
+	if s.writer.groups > 0 {
+		log.Printf("%s committed %d requests in %d groups, mean group size %.1f", s.Name(), s.writer.requests,
+			s.writer.groups, float64(s.writer.requests)/float64(s.writer.groups))
+	}
+	if s.upsert != nil {
+		return s.upsert().Close()
+	}
+	return nil
+}
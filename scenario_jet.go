@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/go-jet/jet/v2/qrm"
+	. "github.com/go-jet/jet/v2/sqlite"
+
+	"github.com/lbe/go-sql-test/gen/model"
+	. "github.com/lbe/go-sql-test/gen/table"
+)
+
+func init() {
+	registerScenario(&jetInsert{})
+	registerScenario(&jetUpdate{})
+	registerScenario(&jetSelect{})
+}
+
+// jetUpsertColumns are the columns written by the Jet upserts
+func jetUpsertColumns() ColumnList {
+	return ColumnList{
+		User.User, User.City, User.Region, User.Country, User.AreaCode, User.ZipCode,
+		User.YearBirth, User.Im, User.Name,
+	}
+}
+
+// jetOnConflictUpdate adds the ON CONFLICT ... DO UPDATE clause of
+// models.StmtUpsertUser to stmt, without its IS NOT guard when
+// opt.noUpsertGuard is set
+func jetOnConflictUpdate(stmt InsertStatement) Statement {
+	action := SET(
+		User.City.SET(User.EXCLUDED.City),
+		User.Region.SET(User.EXCLUDED.Region),
+		User.Country.SET(User.EXCLUDED.Country),
+		User.AreaCode.SET(User.EXCLUDED.AreaCode),
+		User.ZipCode.SET(User.EXCLUDED.ZipCode),
+		User.YearBirth.SET(User.EXCLUDED.YearBirth),
+		User.Im.SET(User.EXCLUDED.Im),
+		User.Name.SET(User.EXCLUDED.Name),
+	)
+	if !opt.noUpsertGuard {
+		action = action.WHERE(
+			OR(User.City.IS_DISTINCT_FROM(User.EXCLUDED.City)).
+				OR(User.Region.IS_DISTINCT_FROM(User.EXCLUDED.Region)).
+				OR(User.Country.IS_DISTINCT_FROM(User.EXCLUDED.Country)).
+				OR(User.AreaCode.IS_DISTINCT_FROM(User.EXCLUDED.AreaCode)).
+				OR(User.ZipCode.IS_DISTINCT_FROM(User.EXCLUDED.ZipCode)).
+				OR(User.YearBirth.IS_DISTINCT_FROM(User.EXCLUDED.YearBirth)).
+				OR(User.Im.IS_DISTINCT_FROM(User.EXCLUDED.Im)).
+				OR(User.Name.IS_DISTINCT_FROM(User.EXCLUDED.Name)),
+		)
+	}
+	return stmt.ON_CONFLICT(User.User).DO_UPDATE(action)
+}
+
+// jetUpsertUser builds the Jet equivalent of models.StmtUpsertUser for rec
+func jetUpsertUser(rec model.User) Statement {
+	return jetOnConflictUpdate(User.INSERT(jetUpsertColumns()).MODEL(rec))
+}
+
+// jetUpsertUsers builds a single Jet upsert of all of recs
+func jetUpsertUsers(recs []model.User) Statement {
+	return jetOnConflictUpdate(User.INSERT(jetUpsertColumns()).MODELS(recs))
+}
+
+// jetInsert builds and executes a Jet upsert for every row
+type jetInsert struct{}
+
+func (s *jetInsert) Name() string           { return "Jet" }
+func (s *jetInsert) Phase() string          { return phaseInsert }
+func (s *jetInsert) Setup(db *sql.DB) error { return nil }
+func (s *jetInsert) Teardown() error        { return nil }
+
+func (s *jetInsert) Run(ex *executor, rec model.User) (int64, error) {
+	return execJetStaged(ex, ex.conn(), jetUpsertUser(rec))
+}
+
+// execJetStaged serializes and executes stmt on db, recording the time spent
+// building stmt (since the start of the operation), serializing it and
+// executing it as separate stages.  It does what stmt.Exec(db) does, only in
+// timed steps
+func execJetStaged(ex *executor, db qrm.Executable, stmt Statement) (int64, error) {
+	ex.stage("build")
+	query, args := stmt.Sql()
+	// sql_debug := stmt.DebugSql()
+	// fmt.Println(sql_debug)
+	ex.stage("serialize")
+	res, err := db.ExecContext(ex.context(), query, args...)
+	ex.stage("exec")
+	return rowsAffected(res, err)
+}
+
+// jetUpdate decrements YearBirth and executes the same upsert as jetInsert
+type jetUpdate struct {
+	jetInsert
+}
+
+func (s *jetUpdate) Phase() string { return phaseUpdate }
+
+func (s *jetUpdate) Run(ex *executor, rec model.User) (int64, error) {
+	*rec.YearBirth--
+	return s.jetInsert.Run(ex, rec)
+}
+
+// queryJetStaged serializes stmt and runs it on db, scanning the result into
+// dest, recording build, serialize and query (execution and scan) as separate
+// stages.  It does what stmt.QueryContext(ctx, db, dest) does, only in timed
+// steps, and so treats an empty result as qrm.ErrNoRows when dest is a struct
+func queryJetStaged(ex *executor, db qrm.Queryable, stmt Statement, dest interface{}) (int64, error) {
+	ex.stage("build")
+	query, args := stmt.Sql()
+	ex.stage("serialize")
+	n, err := qrm.Query(ex.context(), db, query, args, dest)
+	ex.stage("query")
+	return n, err
+}
+
+// jetSelectUser builds the Jet equivalent of models.StmtSelectUser for rec
+func jetSelectUser(rec model.User) Statement {
+	columnList := ColumnList{
+		User.User, User.City, User.Region, User.Country, User.AreaCode, User.ZipCode,
+		User.YearBirth, User.Im, User.Name, User.CreatedTst, User.ChangedTst,
+	}
+
+	return User.SELECT(columnList).
+		FROM(User).
+		WHERE(User.User.EQ(String(rec.User)))
+}
+
+// jetSelect builds a Jet SELECT for every row and scans the result into a
+// model.User, doing the same work as rawSQLSelect
+type jetSelect struct{}
+
+func (s *jetSelect) Name() string           { return "Jet" }
+func (s *jetSelect) Phase() string          { return phaseSelect }
+func (s *jetSelect) Setup(db *sql.DB) error { return nil }
+func (s *jetSelect) Teardown() error        { return nil }
+
+func (s *jetSelect) Run(ex *executor, rec model.User) (int64, error) {
+	var row model.User
+	return queryJetStaged(ex, ex.conn(), jetSelectUser(rec), &row)
+}
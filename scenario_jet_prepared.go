@@ -0,0 +1,82 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/lbe/go-sql-test/gen/model"
+	"github.com/lbe/go-sql-test/stmtcache"
+)
+
+func init() {
+	registerScenario(&jetPreparedInsert{})
+	registerScenario(&jetPreparedUpdate{})
+	registerScenario(&jetPreparedSelect{})
+}
+
+// jetPrepared holds the statement cache shared by the JetPrepared scenarios.
+// The Jet statements are still built and serialized for every row but the
+// resulting SQL text is only prepared once
+type jetPrepared struct {
+	cache *stmtcache.StatementCache
+}
+
+func (s *jetPrepared) Name() string { return "JetPrepared" }
+
+func (s *jetPrepared) Setup(db *sql.DB) error {
+	s.cache = stmtcache.New(db)
+	return nil
+}
+
+func (s *jetPrepared) Teardown() error {
+	// a count above one per statement shape means Jet produced different SQL
+	// text per row
+	log.Printf("statement cache held %d distinct statements", s.cache.Len())
+	return s.cache.Close()
+}
+
+// ReleaseTx drops the statements bound to a committed transaction
+func (s *jetPrepared) ReleaseTx(tx *sql.Tx) {
+	s.cache.Release(tx)
+}
+
+// conn returns the cache's handle bound to the runner's transaction, if any
+func (s *jetPrepared) conn(ex *executor) *stmtcache.DB {
+	return s.cache.DB(ex.tx)
+}
+
+// jetPreparedInsert executes the jetUpsertUser statement through the cache
+type jetPreparedInsert struct {
+	jetPrepared
+}
+
+func (s *jetPreparedInsert) Phase() string { return phaseInsert }
+
+func (s *jetPreparedInsert) Run(ex *executor, rec model.User) (int64, error) {
+	return execJetStaged(ex, s.conn(ex), jetUpsertUser(rec))
+}
+
+// jetPreparedUpdate decrements YearBirth and executes the same upsert as
+// jetPreparedInsert
+type jetPreparedUpdate struct {
+	jetPreparedInsert
+}
+
+func (s *jetPreparedUpdate) Phase() string { return phaseUpdate }
+
+func (s *jetPreparedUpdate) Run(ex *executor, rec model.User) (int64, error) {
+	*rec.YearBirth--
+	return s.jetPreparedInsert.Run(ex, rec)
+}
+
+// jetPreparedSelect runs the same SELECT and scan as jetSelect through the cache
+type jetPreparedSelect struct {
+	jetPrepared
+}
+
+func (s *jetPreparedSelect) Phase() string { return phaseSelect }
+
+func (s *jetPreparedSelect) Run(ex *executor, rec model.User) (int64, error) {
+	var row model.User
+	return queryJetStaged(ex, s.conn(ex), jetSelectUser(rec), &row)
+}
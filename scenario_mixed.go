@@ -0,0 +1,74 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/go-jet/jet/v2/qrm"
+
+	"github.com/lbe/go-sql-test/gen/model"
+)
+
+// errMiss is returned by mixedRead for a row that has not been written yet.
+// The runner counts it in Result.Misses rather than as an error
+var errMiss = errors.New("row not found")
+
+// mixedRead is a select scenario run alongside the write phase with, whose
+// results it is labelled with, e.g. "select+insert"
+type mixedRead struct {
+	Scenario
+	with string
+}
+
+func (s *mixedRead) Phase() string { return s.Scenario.Phase() + "+" + s.with }
+
+func (s *mixedRead) Run(ex *executor, rec model.User) (int64, error) {
+	n, err := s.Scenario.Run(ex, rec)
+	if isNoRows(err) {
+		return n, errMiss
+	}
+	return n, err
+}
+
+// ReleaseTx passes the committed transaction on to the wrapped scenario
+func (s *mixedRead) ReleaseTx(tx *sql.Tx) {
+	if r, ok := s.Scenario.(TxReleaser); ok {
+		r.ReleaseTx(tx)
+	}
+}
+
+// isNoRows reports whether err is the missing row of a select through
+// database/sql or Jet
+func isNoRows(err error) bool {
+	return errors.Is(err, sql.ErrNoRows) || errors.Is(err, qrm.ErrNoRows)
+}
+
+// mixedReader returns the select scenario read alongside the writes of the
+// suite name, which is its own or else that of the access layer it is built
+// on, e.g. RawSQL for RawSQLGroupCommit
+func mixedReader(name string) (s Scenario, ok bool) {
+	layer := ""
+	for _, r := range scenarios {
+		if r.Phase() == phaseSelect && strings.HasPrefix(name, r.Name()) && len(r.Name()) > len(layer) {
+			s, ok, layer = r, true, r.Name()
+		}
+	}
+	return
+}
+
+// runMixed runs a pass of read over data while write runs, returning the
+// result of each.  With -splitPools the reads use the read-only pool
+func runMixed(write, read Scenario, data []model.User) (writeRes, readRes Result, err error) {
+	var wg sync.WaitGroup
+	var readErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		readRes, readErr = runScenario(&mixedRead{Scenario: read, with: write.Phase()}, data)
+	}()
+	writeRes, err = runScenario(write, data)
+	wg.Wait()
+	return writeRes, readRes, errors.Join(err, readErr)
+}
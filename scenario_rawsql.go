@@ -0,0 +1,85 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/lbe/go-sql-test/gen/model"
+	"github.com/lbe/go-sql-test/models"
+)
+
+func init() {
+	registerScenario(&rawSQLInsert{})
+	registerScenario(&rawSQLUpdate{})
+	registerScenario(&rawSQLSelect{})
+}
+
+// rawSQLInsert upserts every row with the prepared models.StmtUpsertUser
+type rawSQLInsert struct {
+	upsertUser func() *sql.Stmt
+}
+
+func (s *rawSQLInsert) Name() string  { return "RawSQL" }
+func (s *rawSQLInsert) Phase() string { return phaseInsert }
+
+func (s *rawSQLInsert) Setup(db *sql.DB) error {
+	if opt.noUpsertGuard {
+		stmt, err := db.Prepare(upsertUsersSQL(1))
+		if err != nil {
+			return err
+		}
+		s.upsertUser = func() *sql.Stmt { return stmt }
+		return nil
+	}
+	s.upsertUser = models.StmtUpsertUser(db)
+	return nil
+}
+
+func (s *rawSQLInsert) Run(ex *executor, rec model.User) (int64, error) {
+	res, err := ex.stmt(s.upsertUser()).ExecContext(ex.context(), rec.User, rec.City, rec.Region, rec.Country, rec.AreaCode,
+		rec.ZipCode, rec.YearBirth, rec.Im, rec.Name)
+	return rowsAffected(res, err)
+}
+
+func (s *rawSQLInsert) Teardown() error {
+	return s.upsertUser().Close()
+}
+
+// rawSQLUpdate decrements YearBirth and upserts the row with the same
+// statement used by rawSQLInsert
+type rawSQLUpdate struct {
+	rawSQLInsert
+}
+
+func (s *rawSQLUpdate) Phase() string { return phaseUpdate }
+
+func (s *rawSQLUpdate) Run(ex *executor, rec model.User) (int64, error) {
+	*rec.YearBirth--
+	return s.rawSQLInsert.Run(ex, rec)
+}
+
+// rawSQLSelect reads every row back with the prepared models.StmtSelectUser
+type rawSQLSelect struct {
+	selectUser func() *sql.Stmt
+}
+
+func (s *rawSQLSelect) Name() string  { return "RawSQL" }
+func (s *rawSQLSelect) Phase() string { return phaseSelect }
+
+func (s *rawSQLSelect) Setup(db *sql.DB) error {
+	s.selectUser = models.StmtSelectUser(db)
+	return nil
+}
+
+func (s *rawSQLSelect) Run(ex *executor, rec model.User) (int64, error) {
+	var row models.RawSqlUser
+	err := ex.stmt(s.selectUser()).QueryRowContext(ex.context(), rec.User).Scan(&row.User, &row.City, &row.Region, &row.Country,
+		&row.AreaCode, &row.ZipCode, &row.YearBirth, &row.Im, &row.Name, &row.CreatedTst, &row.ChangedTst)
+	if err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+func (s *rawSQLSelect) Teardown() error {
+	return s.selectUser().Close()
+}
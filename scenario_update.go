@@ -0,0 +1,331 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	. "github.com/go-jet/jet/v2/sqlite"
+
+	"github.com/lbe/go-sql-test/gen/model"
+	. "github.com/lbe/go-sql-test/gen/table"
+	"github.com/lbe/go-sql-test/models"
+)
+
+// updateStrategies are the alternatives to the ON CONFLICT DO UPDATE upsert
+// selectable with -updateStrategies.  Each one adds an update-only suite per
+// access layer named by strategySuite, e.g. RawSQLPlain or JetTempTable
+var updateStrategies = []string{"plain", "replace", "selectUpdate", "tempTable"}
+
+// strategySuite returns the suite running strategy with access layer layer
+func strategySuite(layer, strategy string) string {
+	return layer + strings.ToUpper(strategy[:1]) + strategy[1:]
+}
+
+// strategyList implements flag.Value for the repeatable -updateStrategies
+// flag, which takes a comma separated list of strategies or "all"
+type strategyList []string
+
+func (l *strategyList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *strategyList) Set(s string) error {
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		names := []string{v}
+		if v == "all" {
+			names = updateStrategies
+		} else if !slices.Contains(updateStrategies, v) {
+			return fmt.Errorf("unknown update strategy %q, must be one of %s or all", v,
+				strings.Join(updateStrategies, ", "))
+		}
+		for _, name := range names {
+			if !slices.Contains(*l, name) {
+				*l = append(*l, name)
+			}
+		}
+	}
+	return nil
+}
+
+func init() {
+	registerScenario(&rawSQLExecUpdate{name: strategySuite("RawSQL", "plain"), query: models.SqlUpdateUser,
+		args: updateArgs})
+	registerScenario(&rawSQLExecUpdate{name: strategySuite("RawSQL", "replace"), query: models.SqlReplaceUser,
+		args: upsertArgs})
+	registerScenario(&rawSQLSelectUpdate{})
+	registerScenario(&rawSQLTempTableUpdate{})
+	registerScenario(&jetPlainUpdate{})
+	registerScenario(&jetReplaceUpdate{})
+	registerScenario(&jetSelectUpdate{})
+	registerScenario(&jetTempTableUpdate{})
+}
+
+// upsertArgs returns the parameters of models.StmtUpsertUser for rec
+func upsertArgs(rec model.User) []interface{} {
+	return []interface{}{rec.User, rec.City, rec.Region, rec.Country, rec.AreaCode, rec.ZipCode, rec.YearBirth,
+		rec.Im, rec.Name}
+}
+
+// updateArgs returns the parameters of models.SqlUpdateUser for rec
+func updateArgs(rec model.User) []interface{} {
+	return []interface{}{rec.City, rec.Region, rec.Country, rec.AreaCode, rec.ZipCode, rec.YearBirth, rec.Im,
+		rec.Name, rec.User}
+}
+
+// rawSQLExecUpdate decrements YearBirth and executes a prepared query with
+// the parameters returned by args.  It implements the plain UPDATE and the
+// INSERT OR REPLACE strategies
+type rawSQLExecUpdate struct {
+	name  string
+	query string
+	args  func(rec model.User) []interface{}
+	stmt  *sql.Stmt
+}
+
+func (s *rawSQLExecUpdate) Name() string  { return s.name }
+func (s *rawSQLExecUpdate) Phase() string { return phaseUpdate }
+
+func (s *rawSQLExecUpdate) Setup(db *sql.DB) (err error) {
+	s.stmt, err = db.Prepare(s.query)
+	return
+}
+
+func (s *rawSQLExecUpdate) Run(ex *executor, rec model.User) (int64, error) {
+	*rec.YearBirth--
+	res, err := ex.stmt(s.stmt).ExecContext(ex.context(), s.args(rec)...)
+	return rowsAffected(res, err)
+}
+
+func (s *rawSQLExecUpdate) Teardown() error {
+	return s.stmt.Close()
+}
+
+// rawSQLSelectUpdate reads the row first and only issues the plain UPDATE
+// when a column differs, moving the IS NOT guard of the upsert into Go
+type rawSQLSelectUpdate struct {
+	selectUser func() *sql.Stmt
+	update     *sql.Stmt
+}
+
+func (s *rawSQLSelectUpdate) Name() string  { return strategySuite("RawSQL", "selectUpdate") }
+func (s *rawSQLSelectUpdate) Phase() string { return phaseUpdate }
+
+func (s *rawSQLSelectUpdate) Setup(db *sql.DB) (err error) {
+	s.selectUser = models.StmtSelectUser(db)
+	s.update, err = db.Prepare(models.SqlUpdateUser)
+	return
+}
+
+func (s *rawSQLSelectUpdate) Run(ex *executor, rec model.User) (int64, error) {
+	*rec.YearBirth--
+	var row models.RawSqlUser
+	err := ex.stmt(s.selectUser()).QueryRowContext(ex.context(), rec.User).Scan(&row.User, &row.City, &row.Region,
+		&row.Country, &row.AreaCode, &row.ZipCode, &row.YearBirth, &row.Im, &row.Name, &row.CreatedTst, &row.ChangedTst)
+	if err != nil {
+		return 0, err
+	}
+	got := model.User{User: row.User, City: row.City, Region: row.Region, Country: row.Country,
+		AreaCode: row.AreaCode, ZipCode: row.ZipCode, YearBirth: row.YearBirth, Im: row.Im, Name: row.Name}
+	if len(compareUser(got, rec)) == 0 {
+		return 0, nil
+	}
+	res, err := ex.stmt(s.update).ExecContext(ex.context(), updateArgs(rec)...)
+	return rowsAffected(res, err)
+}
+
+func (s *rawSQLSelectUpdate) Teardown() error {
+	return errors.Join(s.selectUser().Close(), s.update.Close())
+}
+
+// withUserStage runs fn with a transaction in which the TEMP staging table
+// exists and is empty.  A TEMP table only exists on the connection that
+// created it, so without the runner's transaction one is begun for the batch
+func withUserStage(ex *executor, fn func(tx *sql.Tx) (int64, error)) (n int64, err error) {
+	tx := ex.tx
+	if tx == nil {
+		if tx, err = ex.db.BeginTx(ex.context(), nil); err != nil {
+			return
+		}
+		defer func() {
+			if err != nil {
+				tx.Rollback()
+				return
+			}
+			err = tx.Commit()
+		}()
+	}
+	if _, err = tx.ExecContext(ex.context(), models.SqlCreateUserStage); err != nil {
+		return
+	}
+	if _, err = tx.ExecContext(ex.context(), models.SqlDeleteUserStage); err != nil {
+		return
+	}
+	return fn(tx)
+}
+
+// rawSQLTempTableUpdate stages -batchSize rows in a TEMP table and applies
+// them with a single UPDATE ... FROM
+type rawSQLTempTableUpdate struct{}
+
+func (s *rawSQLTempTableUpdate) Name() string           { return strategySuite("RawSQL", "tempTable") }
+func (s *rawSQLTempTableUpdate) Phase() string          { return phaseUpdate }
+func (s *rawSQLTempTableUpdate) Setup(db *sql.DB) error { return nil }
+func (s *rawSQLTempTableUpdate) Teardown() error        { return nil }
+
+func (s *rawSQLTempTableUpdate) Run(ex *executor, rec model.User) (int64, error) {
+	return s.RunBatch(ex, []model.User{rec})
+}
+
+func (s *rawSQLTempTableUpdate) RunBatch(ex *executor, recs []model.User) (int64, error) {
+	return withUserStage(ex, func(tx *sql.Tx) (int64, error) {
+		stmt, err := tx.PrepareContext(ex.context(), models.SqlInsertUserStage)
+		if err != nil {
+			return 0, err
+		}
+		defer stmt.Close()
+		for _, rec := range recs {
+			*rec.YearBirth--
+		}
+		for _, rec := range distinctUsers(recs) {
+			if _, err = stmt.ExecContext(ex.context(), upsertArgs(rec)...); err != nil {
+				return 0, err
+			}
+		}
+		res, err := tx.ExecContext(ex.context(), models.SqlUpdateUserFromStage)
+		return rowsAffected(res, err)
+	})
+}
+
+// distinctUsers returns the first of every user in recs.  Keys drawn with
+// -keyDist can repeat within a batch, but the staging table holds a user once,
+// and the repeats share the values the batch has written to the first
+func distinctUsers(recs []model.User) []model.User {
+	seen := make(map[string]bool, len(recs))
+	users := make([]model.User, 0, len(recs))
+	for _, rec := range recs {
+		if !seen[rec.User] {
+			seen[rec.User] = true
+			users = append(users, rec)
+		}
+	}
+	return users
+}
+
+// jetUpdateUser builds the Jet equivalent of models.SqlUpdateUser for rec
+func jetUpdateUser(rec model.User) Statement {
+	return User.UPDATE(jetUpsertColumns()[1:]).
+		MODEL(rec).
+		WHERE(User.User.EQ(String(rec.User)))
+}
+
+// jetPlainUpdate builds and executes a Jet UPDATE for every row
+type jetPlainUpdate struct{}
+
+func (s *jetPlainUpdate) Name() string           { return strategySuite("Jet", "plain") }
+func (s *jetPlainUpdate) Phase() string          { return phaseUpdate }
+func (s *jetPlainUpdate) Setup(db *sql.DB) error { return nil }
+func (s *jetPlainUpdate) Teardown() error        { return nil }
+
+func (s *jetPlainUpdate) Run(ex *executor, rec model.User) (int64, error) {
+	*rec.YearBirth--
+	return execJetStaged(ex, ex.conn(), jetUpdateUser(rec))
+}
+
+// jetReplaceUpdate builds a Jet INSERT for every row and executes it as an
+// INSERT OR REPLACE.  The SQLite dialect of Jet has no OR REPLACE, so the
+// keyword is added to the serialized statement
+type jetReplaceUpdate struct{}
+
+func (s *jetReplaceUpdate) Name() string           { return strategySuite("Jet", "replace") }
+func (s *jetReplaceUpdate) Phase() string          { return phaseUpdate }
+func (s *jetReplaceUpdate) Setup(db *sql.DB) error { return nil }
+func (s *jetReplaceUpdate) Teardown() error        { return nil }
+
+func (s *jetReplaceUpdate) Run(ex *executor, rec model.User) (int64, error) {
+	*rec.YearBirth--
+	stmt := User.INSERT(jetUpsertColumns()).MODEL(rec)
+	ex.stage("build")
+	query, args := stmt.Sql()
+	query = strings.Replace(query, "INSERT INTO", "INSERT OR REPLACE INTO", 1)
+	ex.stage("serialize")
+	res, err := ex.conn().ExecContext(ex.context(), query, args...)
+	ex.stage("exec")
+	return rowsAffected(res, err)
+}
+
+// jetSelectUpdate reads the row with jetSelectUser and only executes
+// jetUpdateUser when a column differs
+type jetSelectUpdate struct{}
+
+func (s *jetSelectUpdate) Name() string           { return strategySuite("Jet", "selectUpdate") }
+func (s *jetSelectUpdate) Phase() string          { return phaseUpdate }
+func (s *jetSelectUpdate) Setup(db *sql.DB) error { return nil }
+func (s *jetSelectUpdate) Teardown() error        { return nil }
+
+func (s *jetSelectUpdate) Run(ex *executor, rec model.User) (int64, error) {
+	*rec.YearBirth--
+	var row model.User
+	if _, err := queryJetStaged(ex, ex.conn(), jetSelectUser(rec), &row); err != nil {
+		return 0, err
+	}
+	if len(compareUser(row, rec)) == 0 {
+		return 0, nil
+	}
+	return execJetStaged(ex, ex.conn(), jetUpdateUser(rec))
+}
+
+// userStage is the TEMP staging table created by models.SqlCreateUserStage,
+// which has the columns of User
+var userStage = User.FromSchema("temp").WithSuffix("_stage").AS("stage")
+
+// jetUpdateFromStage builds the Jet equivalent of models.SqlUpdateUserFromStage
+func jetUpdateFromStage() Statement {
+	return User.UPDATE().
+		SET(
+			User.City.SET(userStage.City),
+			User.Region.SET(userStage.Region),
+			User.Country.SET(userStage.Country),
+			User.AreaCode.SET(userStage.AreaCode),
+			User.ZipCode.SET(userStage.ZipCode),
+			User.YearBirth.SET(userStage.YearBirth),
+			User.Im.SET(userStage.Im),
+			User.Name.SET(userStage.Name),
+		).
+		FROM(userStage).
+		WHERE(User.User.EQ(userStage.User))
+}
+
+// jetTempTableUpdate stages -batchSize rows in the TEMP table with a Jet
+// multi-row INSERT and applies them with jetUpdateFromStage
+type jetTempTableUpdate struct{}
+
+func (s *jetTempTableUpdate) Name() string           { return strategySuite("Jet", "tempTable") }
+func (s *jetTempTableUpdate) Phase() string          { return phaseUpdate }
+func (s *jetTempTableUpdate) Setup(db *sql.DB) error { return nil }
+func (s *jetTempTableUpdate) Teardown() error        { return nil }
+
+func (s *jetTempTableUpdate) Run(ex *executor, rec model.User) (int64, error) {
+	return s.RunBatch(ex, []model.User{rec})
+}
+
+func (s *jetTempTableUpdate) RunBatch(ex *executor, recs []model.User) (int64, error) {
+	return withUserStage(ex, func(tx *sql.Tx) (int64, error) {
+		for _, rec := range recs {
+			*rec.YearBirth--
+		}
+		stage := userStage.INSERT(userStage.User, userStage.City, userStage.Region, userStage.Country,
+			userStage.AreaCode, userStage.ZipCode, userStage.YearBirth, userStage.Im, userStage.Name).
+			MODELS(distinctUsers(recs))
+		if _, err := execJetStaged(ex, tx, stage); err != nil {
+			return 0, err
+		}
+		return execJetStaged(ex, tx, jetUpdateFromStage())
+	})
+}
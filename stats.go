@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"text/tabwriter"
+	"time"
+)
+
+// Stats summarizes a sample of repeated measurements
+type Stats struct {
+	N      int
+	Mean   float64
+	StdDev float64
+	Min    float64
+	Max    float64
+	// CI95 is the half width of the 95% confidence interval of the mean
+	CI95 float64
+}
+
+// tCritical95 holds the two-sided 95% critical values of Student's t
+// distribution for 1 to 30 degrees of freedom
+var tCritical95 = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// summarize computes the sample statistics of values
+func summarize(values []float64) (s Stats) {
+	s.N = len(values)
+	if s.N == 0 {
+		return
+	}
+	s.Min, s.Max = math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		s.Mean += v
+		s.Min = math.Min(s.Min, v)
+		s.Max = math.Max(s.Max, v)
+	}
+	s.Mean /= float64(s.N)
+	if s.N < 2 {
+		return
+	}
+
+	var ss float64
+	for _, v := range values {
+		ss += (v - s.Mean) * (v - s.Mean)
+	}
+	s.StdDev = math.Sqrt(ss / float64(s.N-1))
+
+	t := 1.960
+	if df := s.N - 1; df <= len(tCritical95) {
+		t = tCritical95[df-1]
+	}
+	s.CI95 = t * s.StdDev / math.Sqrt(float64(s.N))
+	return
+}
+
+// resultGroup collects the results of every iteration of one scenario phase
+// run with one sweep combination
+type resultGroup struct {
+	Sweep    string
+	Scenario string
+	Phase    string
+	Results  []Result
+}
+
+// key identifies the scenario phase and sweep combination of g
+func (g *resultGroup) key() [3]string {
+	return [3]string{g.Sweep, g.Scenario, g.Phase}
+}
+
+// groupResults groups results by sweep combination, scenario and phase,
+// keeping first-seen order
+func groupResults(results []Result) (groups []*resultGroup) {
+	index := map[[3]string]*resultGroup{}
+	for _, r := range results {
+		key := [3]string{r.Config.Sweep, r.Scenario, r.Phase}
+		g, ok := index[key]
+		if !ok {
+			g = &resultGroup{Sweep: r.Config.Sweep, Scenario: r.Scenario, Phase: r.Phase}
+			index[key] = g
+			groups = append(groups, g)
+		}
+		g.Results = append(g.Results, r)
+	}
+	return
+}
+
+// opsPerSecStats returns the statistics of the throughput of g's iterations
+func (g *resultGroup) opsPerSecStats() Stats {
+	values := make([]float64, len(g.Results))
+	for i, r := range g.Results {
+		values[i] = r.OpsPerSec()
+	}
+	return summarize(values)
+}
+
+// wallTimeStats returns the statistics of the wall time in seconds of g's iterations
+func (g *resultGroup) wallTimeStats() Stats {
+	values := make([]float64, len(g.Results))
+	for i, r := range g.Results {
+		values[i] = r.Duration().Seconds()
+	}
+	return summarize(values)
+}
+
+// printStats writes a table of the per-phase statistics across iterations to w
+func printStats(w io.Writer, results []Result) {
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Scenario\tPhase\tN\tMean ops/sec\tStdDev\tMin\tMax\t95% CI\tMean wall time\t")
+	for _, g := range groupResults(results) {
+		ops := g.opsPerSecStats()
+		wall := g.wallTimeStats()
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.0f\t%.0f\t%.0f\t%.0f\t±%.0f\t%s\t\n", g.Scenario, g.Phase, ops.N, ops.Mean,
+			ops.StdDev, ops.Min, ops.Max, ops.CI95, time.Duration(wall.Mean*float64(time.Second)).Round(time.Millisecond))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,163 @@
+// Package stmtcache prepares the SQL text produced by go-jet statements once
+// and reuses the resulting *sql.Stmt for every later execution of the same
+// text.  go-jet serializes a statement with placeholders, so statements built
+// from the same builder tree share their SQL text even when the values differ.
+package stmtcache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+)
+
+// StatementCache holds the statements prepared on a *sql.DB keyed by their
+// SQL text, together with the copies bound to each *sql.Tx they were used in
+type StatementCache struct {
+	db    *sql.DB
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+	texts map[string]struct{}
+	txs   map[*sql.Tx]map[string]*sql.Stmt
+}
+
+// New returns an empty StatementCache preparing its statements on db
+func New(db *sql.DB) *StatementCache {
+	return &StatementCache{
+		db:    db,
+		stmts: map[string]*sql.Stmt{},
+		texts: map[string]struct{}{},
+		txs:   map[*sql.Tx]map[string]*sql.Stmt{},
+	}
+}
+
+// Stmt returns the prepared statement for query, preparing it on db on first
+// use.  When tx is not nil the statement is bound to tx.  A query first seen in
+// a transaction while every connection of the pool is in use is prepared on tx
+// alone, as preparing it on db would wait for a connection that tx may hold;
+// it is prepared on db by the next call that finds a connection free
+func (c *StatementCache) Stmt(ctx context.Context, tx *sql.Tx, query string) (*sql.Stmt, error) {
+	c.mu.Lock()
+	c.texts[query] = struct{}{}
+	stmt, ok := c.stmts[query]
+	c.mu.Unlock()
+	if !ok && (tx == nil || !c.exhausted()) {
+		// prepared without holding mu, so that waiting for a connection does
+		// not stall the workers that already hold one
+		prepared, err := c.db.PrepareContext(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		if stmt, ok = c.stmts[query]; ok {
+			prepared.Close()
+		} else {
+			stmt = prepared
+			c.stmts[query] = stmt
+		}
+		c.mu.Unlock()
+	}
+	if tx == nil {
+		return stmt, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	txStmts, ok := c.txs[tx]
+	if !ok {
+		txStmts = map[string]*sql.Stmt{}
+		c.txs[tx] = txStmts
+	}
+	txStmt, ok := txStmts[query]
+	if !ok {
+		if stmt != nil {
+			txStmt = tx.StmtContext(ctx, stmt)
+		} else {
+			var err error
+			if txStmt, err = tx.PrepareContext(ctx, query); err != nil {
+				return nil, err
+			}
+		}
+		txStmts[query] = txStmt
+	}
+	return txStmt, nil
+}
+
+// exhausted reports whether every connection the pool may open is in use
+func (c *StatementCache) exhausted() bool {
+	s := c.db.Stats()
+	return s.MaxOpenConnections > 0 && s.InUse >= s.MaxOpenConnections
+}
+
+// Release forgets the statements bound to tx.  It should be called once tx
+// has been committed or rolled back, which also closes those statements
+func (c *StatementCache) Release(tx *sql.Tx) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.txs, tx)
+}
+
+// Len returns the number of distinct SQL texts prepared
+func (c *StatementCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.texts)
+}
+
+// Close closes every prepared statement and empties the cache
+func (c *StatementCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs []error
+	for _, stmt := range c.stmts {
+		if err := stmt.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	c.stmts = map[string]*sql.Stmt{}
+	c.texts = map[string]struct{}{}
+	c.txs = map[*sql.Tx]map[string]*sql.Stmt{}
+	return errors.Join(errs...)
+}
+
+// DB returns a handle implementing go-jet's qrm.DB that runs every query
+// through the cache, bound to tx when it is not nil.  It is passed to a jet
+// statement's Exec or Query in place of the *sql.DB or *sql.Tx
+func (c *StatementCache) DB(tx *sql.Tx) *DB {
+	return &DB{cache: c, tx: tx}
+}
+
+// DB executes queries with statements from a StatementCache
+type DB struct {
+	cache *StatementCache
+	tx    *sql.Tx
+}
+
+// Exec executes query with args using the cached statement
+func (d *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return d.ExecContext(context.Background(), query, args...)
+}
+
+// ExecContext executes query with args using the cached statement
+func (d *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	stmt, err := d.cache.Stmt(ctx, d.tx, query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.ExecContext(ctx, args...)
+}
+
+// Query runs query with args using the cached statement
+func (d *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return d.QueryContext(context.Background(), query, args...)
+}
+
+// QueryContext runs query with args using the cached statement
+func (d *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	stmt, err := d.cache.Stmt(ctx, d.tx, query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.QueryContext(ctx, args...)
+}
@@ -0,0 +1,158 @@
+package stmtcache
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const insertKV = "INSERT INTO kv (k, v) VALUES (?, ?)"
+
+// openTestDB returns a file database with a kv table allowing maxOpen
+// connections
+func openTestDB(t *testing.T, maxOpen int) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(maxOpen)
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec("CREATE TABLE kv (k INTEGER PRIMARY KEY, v TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestStmtAcrossTransactions(t *testing.T) {
+	db := openTestDB(t, 2)
+	c := New(db)
+	defer c.Close()
+	ctx := context.Background()
+
+	var dbStmt *sql.Stmt
+	for i := 0; i < 3; i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stmt, err := c.Stmt(ctx, tx, insertKV)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again, _ := c.Stmt(ctx, tx, insertKV); again != stmt {
+			t.Errorf("tx %d: second Stmt returned another statement", i)
+		}
+		if _, err := stmt.Exec(i, "v"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		c.Release(tx)
+
+		// the statement first seen in a transaction is prepared on db once
+		if len(c.stmts) != 1 {
+			t.Fatalf("tx %d: %d statements prepared on db, want 1", i, len(c.stmts))
+		}
+		if dbStmt == nil {
+			dbStmt = c.stmts[insertKV]
+		} else if c.stmts[insertKV] != dbStmt {
+			t.Errorf("tx %d: statement prepared on db again", i)
+		}
+	}
+	if c.Len() != 1 {
+		t.Errorf("Len = %d, want 1", c.Len())
+	}
+	if len(c.txs) != 0 {
+		t.Errorf("%d transactions left after Release", len(c.txs))
+	}
+}
+
+func TestStmtSingleConnection(t *testing.T) {
+	db := openTestDB(t, 1)
+	c := New(db)
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the transaction holds the only connection, so the statement cannot be
+	// prepared on db without waiting forever
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := c.Stmt(ctx, tx, insertKV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stmt.Exec(1, "v"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	c.Release(tx)
+	if len(c.stmts) != 0 {
+		t.Errorf("%d statements prepared on db while the pool was exhausted, want 0", len(c.stmts))
+	}
+
+	if _, err := c.Stmt(ctx, nil, insertKV); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.stmts) != 1 || c.Len() != 1 {
+		t.Errorf("stmts/Len = %d/%d, want 1/1", len(c.stmts), c.Len())
+	}
+}
+
+func TestDB(t *testing.T) {
+	db := openTestDB(t, 2)
+	c := New(db)
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.DB(nil).Exec(insertKV, i, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows, err := c.DB(nil).Query("SELECT count(*) FROM kv WHERE v = ?", "v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var n int
+	for rows.Next() {
+		if err := rows.Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n != 3 {
+		t.Errorf("count = %d, want 3", n)
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len = %d, want 2", c.Len())
+	}
+}
+
+func TestClose(t *testing.T) {
+	db := openTestDB(t, 2)
+	c := New(db)
+
+	stmt, err := c.Stmt(context.Background(), nil, insertKV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 0 {
+		t.Errorf("Len = %d after Close, want 0", c.Len())
+	}
+	if _, err := stmt.Exec(1, "v"); err == nil {
+		t.Errorf("statement still usable after Close")
+	}
+}
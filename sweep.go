@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lbe/go-sql-test/gen/model"
+)
+
+// sweep dimensions setting command line flags; every other dimension names a
+// PRAGMA
+const (
+	sweepUseTransaction = "useTransaction"
+	sweepBatchSize      = "batchSize"
+	sweepCommitEvery    = "commitEvery"
+	sweepWorkers        = "workers"
+	sweepMaxOpenConns   = "maxOpenConns"
+	sweepOrder          = "order"
+)
+
+// sweepDim is one dimension of a sweep: a setting and the values it takes
+type sweepDim struct {
+	Name   string
+	Values []string
+}
+
+// sweepSetting is a single value chosen for a sweep dimension
+type sweepSetting struct {
+	Name  string
+	Value string
+}
+
+// sweepList implements flag.Value for the repeatable -sweep flag
+type sweepList []sweepDim
+
+func (l *sweepList) String() string {
+	if l == nil {
+		return ""
+	}
+	s := make([]string, len(*l))
+	for i, d := range *l {
+		s[i] = d.Name + "=" + strings.Join(d.Values, ",")
+	}
+	return strings.Join(s, " ")
+}
+
+func (l *sweepList) Set(s string) error {
+	name, values, ok := strings.Cut(s, "=")
+	if !ok || values == "" {
+		return fmt.Errorf("sweep %q is not name=value1,value2,...", s)
+	}
+	d := sweepDim{Name: strings.TrimSpace(name)}
+	for _, v := range strings.Split(values, ",") {
+		v = strings.TrimSpace(v)
+		switch d.Name {
+		case sweepUseTransaction:
+			if _, err := strconv.ParseBool(v); err != nil {
+				return fmt.Errorf("invalid value %q for %s", v, d.Name)
+			}
+		case sweepBatchSize:
+			if n, err := strconv.Atoi(v); err != nil || n < 1 || n > maxBatchSize {
+				return fmt.Errorf("invalid value %q for %s, must be 1 to %d", v, d.Name, maxBatchSize)
+			}
+		case sweepCommitEvery, sweepMaxOpenConns:
+			if n, err := strconv.Atoi(v); err != nil || n < 0 {
+				return fmt.Errorf("invalid value %q for %s", v, d.Name)
+			}
+		case sweepWorkers:
+			if n, err := strconv.Atoi(v); err != nil || n < 1 {
+				return fmt.Errorf("invalid value %q for %s", v, d.Name)
+			}
+		case sweepOrder:
+			if err := checkOrder(v); err != nil {
+				return err
+			}
+		default:
+			p, err := parsePragma(d.Name + "=" + v)
+			if err != nil {
+				return err
+			}
+			d.Name = p.Name
+		}
+		d.Values = append(d.Values, v)
+	}
+	*l = append(*l, d)
+	return nil
+}
+
+// has reports whether l sweeps over the setting name
+func (l sweepList) has(name string) bool {
+	for _, d := range l {
+		if d.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// combinations returns every combination of the sweep's values, varying the
+// last dimension fastest
+func (l sweepList) combinations() [][]sweepSetting {
+	combos := [][]sweepSetting{nil}
+	for _, d := range l {
+		var next [][]sweepSetting
+		for _, c := range combos {
+			for _, v := range d.Values {
+				combo := append(append([]sweepSetting{}, c...), sweepSetting{d.Name, v})
+				next = append(next, combo)
+			}
+		}
+		combos = next
+	}
+	return combos
+}
+
+// sweepLabel renders combo as "name=value" pairs in dimension order
+func sweepLabel(combo []sweepSetting) string {
+	s := make([]string, len(combo))
+	for i, c := range combo {
+		s[i] = c.Name + "=" + c.Value
+	}
+	return strings.Join(s, " ")
+}
+
+// runSweep runs the selected suites once for every combination of the -sweep
+// settings.  The database is recreated with dbInit for every combination as
+// settings such as journal_mode and page_size only fully apply to a new file
+func runSweep(data []model.User) (results []Result, err error) {
+	basePragmas := append(pragmaList{}, opt.pragmas...)
+	baseUseTransaction := *opt.useTransaction
+	baseBatchSize := *opt.batchSize
+	baseCommitEvery := *opt.commitEvery
+	baseWorkers := *opt.workers
+	baseMaxOpenConns := *opt.maxOpenConns
+	baseOrder := *opt.order
+	defer func() {
+		opt.pragmas = basePragmas
+		*opt.useTransaction = baseUseTransaction
+		*opt.batchSize = baseBatchSize
+		*opt.commitEvery = baseCommitEvery
+		*opt.workers = baseWorkers
+		*opt.maxOpenConns = baseMaxOpenConns
+		*opt.order = baseOrder
+		opt.sweepLabel = ""
+	}()
+
+	combos := opt.sweep.combinations()
+	for i, combo := range combos {
+		opt.pragmas = append(pragmaList{}, basePragmas...)
+		*opt.useTransaction = baseUseTransaction
+		*opt.batchSize = baseBatchSize
+		*opt.commitEvery = baseCommitEvery
+		*opt.workers = baseWorkers
+		*opt.maxOpenConns = baseMaxOpenConns
+		*opt.order = baseOrder
+		for _, c := range combo {
+			switch c.Name {
+			case sweepUseTransaction:
+				*opt.useTransaction, _ = strconv.ParseBool(c.Value)
+			case sweepBatchSize:
+				*opt.batchSize, _ = strconv.Atoi(c.Value)
+			case sweepCommitEvery:
+				*opt.commitEvery, _ = strconv.Atoi(c.Value)
+			case sweepWorkers:
+				*opt.workers, _ = strconv.Atoi(c.Value)
+			case sweepMaxOpenConns:
+				*opt.maxOpenConns, _ = strconv.Atoi(c.Value)
+			case sweepOrder:
+				*opt.order = c.Value
+			default:
+				if err = opt.pragmas.Set(c.Name + "=" + c.Value); err != nil {
+					return
+				}
+			}
+		}
+		opt.sweepLabel = sweepLabel(combo)
+		log.Printf("Sweep %d of %d: %s", i+1, len(combos), opt.sweepLabel)
+
+		dbClose()
+		if err = dbInit(); err != nil {
+			return
+		}
+		res, err := runSuites(data)
+		results = append(results, res...)
+		if err != nil {
+			return results, err
+		}
+	}
+	return
+}
+
+// printSweepSummary writes one consolidated table with a row per sweep
+// combination, scenario and phase to w
+func printSweepSummary(w io.Writer, results []Result) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Settings\tScenario\tPhase\tN\tMean ops/sec\t95% CI\tp50\tp99\tMax\t")
+	for _, g := range groupResults(results) {
+		ops := g.opsPerSecStats()
+		lat := g.latency()
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%.0f\t±%.0f\t%s\t%s\t%s\t\n", g.Sweep, g.Scenario, g.Phase, ops.N,
+			ops.Mean, ops.CI95, roundLatency(lat.Percentile(50)), roundLatency(lat.Percentile(99)),
+			roundLatency(lat.Max()))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/lbe/go-sql-test/gen/model"
+)
+
+// maxLoggedMismatches limits how many mismatches verifyData logs per phase
+const maxLoggedMismatches = 10
+
+// verifyData reads every row of the user table back and compares all columns
+// with data, which holds the values the scenarios wrote, including the
+// decremented YearBirth of updated rows.  It returns a description of every
+// difference, rows missing from the table and rows not in data
+func verifyData(db *sql.DB, data []model.User) (mismatches []string, err error) {
+	rows, err := db.Query(`
+		SELECT user, city, region, country, area_code, zip_code, year_birth, im, name
+		  FROM user;`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	stored := make(map[string]model.User, len(data))
+	for rows.Next() {
+		var u model.User
+		err = rows.Scan(&u.User, &u.City, &u.Region, &u.Country, &u.AreaCode, &u.ZipCode, &u.YearBirth,
+			&u.Im, &u.Name)
+		if err != nil {
+			return
+		}
+		stored[u.User] = u
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	for _, want := range data {
+		got, ok := stored[want.User]
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("user %s: missing", want.User))
+			continue
+		}
+		delete(stored, want.User)
+		mismatches = append(mismatches, compareUser(got, want)...)
+	}
+	for user := range stored {
+		mismatches = append(mismatches, fmt.Sprintf("user %s: not in generated data", user))
+	}
+	return
+}
+
+// compareUser describes every column in which got differs from want
+func compareUser(got, want model.User) (diffs []string) {
+	columns := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"city", got.City, want.City},
+		{"region", got.Region, want.Region},
+		{"country", got.Country, want.Country},
+		{"area_code", got.AreaCode, want.AreaCode},
+		{"zip_code", got.ZipCode, want.ZipCode},
+		{"year_birth", got.YearBirth, want.YearBirth},
+		{"im", got.Im, want.Im},
+		{"name", got.Name, want.Name},
+	}
+	for _, c := range columns {
+		g, w := ptrString(c.got), ptrString(c.want)
+		if g != w {
+			diffs = append(diffs, fmt.Sprintf("user %s: %s is %s, want %s", want.User, c.name, g, w))
+		}
+	}
+	return
+}
+
+// ptrString renders the value of a nullable column, or NULL
+func ptrString(v interface{}) string {
+	switch p := v.(type) {
+	case *string:
+		if p != nil {
+			return fmt.Sprintf("%q", *p)
+		}
+	case *int32:
+		if p != nil {
+			return fmt.Sprint(*p)
+		}
+	}
+	return "NULL"
+}
+
+// verifyPhase checks the table against data after phase res of a scenario,
+// logs the first mismatches and records their number in res
+func verifyPhase(res *Result, data []model.User) error {
+	mismatches, err := verifyData(opt.db, data)
+	if err != nil {
+		return fmt.Errorf("verify %s %s: %w", res.Scenario, res.Phase, err)
+	}
+	res.Verified = true
+	res.Mismatches = int64(len(mismatches))
+	log.Printf("Verify %s %s: %d rows checked, %d mismatches", res.Scenario, res.Phase, len(data), len(mismatches))
+	for i, m := range mismatches {
+		if i == maxLoggedMismatches {
+			log.Printf("[warning] ... %d more mismatches", len(mismatches)-i)
+			break
+		}
+		log.Printf("[warning] %s", m)
+	}
+	return nil
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+
+	"github.com/lbe/go-sql-test/gen/model"
+)
+
+// opReadModifyWrite is the operation of a workloadMix that selects a row and
+// then updates it on the same connection, as in YCSB workload F, and in the
+// same transaction with -useTransaction or -commitEvery.  The other operations
+// are the phases of the same name
+const opReadModifyWrite = "rmw"
+
+// workloadOps lists the operations a workloadMix can contain
+var workloadOps = []string{phaseSelect, phaseUpdate, phaseInsert, opReadModifyWrite}
+
+// workloadSeed plus the worker index seeds each worker's operations and keys,
+// so that every suite and iteration runs the same sequence
+const workloadSeed = 1
+
+// workloadPresets are the YCSB core workloads that the scenarios can model.
+// Workload E is missing as there are no range scans
+var workloadPresets = map[string]string{
+	"a": "select=50,update=50",
+	"b": "select=95,update=5",
+	"c": "select=100",
+	"d": "select=95,insert=5",
+	"f": "select=50,rmw=50",
+}
+
+// mixEntry is the relative weight of one operation of a workloadMix
+type mixEntry struct {
+	op     string
+	weight int
+}
+
+// workloadMix is the -workload flag, the operations of a mixed workload and
+// their weights, given as op=weight,... or as the letter of a workloadPresets
+type workloadMix []mixEntry
+
+func (m *workloadMix) String() string {
+	if m == nil {
+		return ""
+	}
+	var parts []string
+	for _, e := range *m {
+		parts = append(parts, e.op+"="+strconv.Itoa(e.weight))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (m *workloadMix) Set(value string) error {
+	if preset, ok := workloadPresets[strings.ToLower(value)]; ok {
+		value = preset
+	} else if strings.EqualFold(value, "e") {
+		return fmt.Errorf("workload e needs range scans, which the scenarios do not have")
+	}
+	var mix workloadMix
+	seen := map[string]bool{}
+	for _, part := range strings.Split(value, ",") {
+		op, weight, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("invalid operation %q, want op=weight", part)
+		}
+		op = strings.TrimSpace(op)
+		known := false
+		for _, o := range workloadOps {
+			known = known || o == op
+		}
+		if !known {
+			return fmt.Errorf("unknown operation %q, want one of %s", op, strings.Join(workloadOps, ", "))
+		}
+		if seen[op] {
+			return fmt.Errorf("operation %s given more than once", op)
+		}
+		seen[op] = true
+		w, err := strconv.Atoi(strings.TrimSpace(weight))
+		if err != nil || w < 0 {
+			return fmt.Errorf("invalid weight %q of %s", weight, op)
+		}
+		if w > 0 {
+			mix = append(mix, mixEntry{op, w})
+		}
+	}
+	if len(mix) == 0 {
+		return fmt.Errorf("workload %q has no operations", value)
+	}
+	*m = mix
+	return nil
+}
+
+// pick draws an operation with probability proportional to its weight
+func (m workloadMix) pick(rng *rand.Rand) string {
+	total := 0
+	for _, e := range m {
+		total += e.weight
+	}
+	n := rng.Intn(total)
+	for _, e := range m {
+		if n < e.weight {
+			return e.op
+		}
+		n -= e.weight
+	}
+	return m[len(m)-1].op
+}
+
+// has reports whether m contains op
+func (m workloadMix) has(op string) bool {
+	for _, e := range m {
+		if e.op == op {
+			return true
+		}
+	}
+	return false
+}
+
+// phases returns the phases whose scenarios the operations of m run
+func (m workloadMix) phases() (phases []string) {
+	for _, phase := range []string{phaseInsert, phaseUpdate, phaseSelect} {
+		for _, e := range m {
+			if e.op == phase || e.op == opReadModifyWrite && phase != phaseInsert {
+				phases = append(phases, phase)
+				break
+			}
+		}
+	}
+	return
+}
+
+// keySpace holds the rows of a mixed workload, the generated data followed by
+// the rows inserted so far, and the values last written to each of them
+type keySpace struct {
+	mu   sync.RWMutex
+	recs []model.User
+}
+
+// get returns row i with a YearBirth of its own, which the update scenarios
+// may decrement without touching the shared data
+func (k *keySpace) get(i int) model.User {
+	k.mu.RLock()
+	rec := k.recs[i]
+	k.mu.RUnlock()
+	if rec.YearBirth != nil {
+		y := *rec.YearBirth
+		rec.YearBirth = &y
+	}
+	return rec
+}
+
+// choose returns a row drawn by c and its index
+func (k *keySpace) choose(c keyChooser, rng *rand.Rand) (int, model.User) {
+	k.mu.RLock()
+	i := c.next(rng, len(k.recs))
+	k.mu.RUnlock()
+	return i, k.get(i)
+}
+
+// put records rec as the new value of row i
+func (k *keySpace) put(i int, rec model.User) {
+	k.mu.Lock()
+	k.recs[i] = rec
+	k.mu.Unlock()
+}
+
+// add appends an inserted row
+func (k *keySpace) add(rec model.User) {
+	k.mu.Lock()
+	k.recs = append(k.recs, rec)
+	k.mu.Unlock()
+}
+
+// workloadDraws draws the operations and keys of one worker of a workload
+type workloadDraws struct {
+	rng  *rand.Rand
+	keys keyChooser
+}
+
+// choose returns a row of the key space drawn by d and its index
+func (d *workloadDraws) choose(k *keySpace) (int, model.User) {
+	return k.choose(d.keys, d.rng)
+}
+
+// workloadRun is a mixed workload running against the scenarios of one suite
+type workloadRun struct {
+	scenarios map[string]Scenario // by phase, and the select of rmw by opReadModifyWrite
+	ready     []string            // keys of the scenarios set up
+	keys      *keySpace
+	inserted  atomic.Int64
+	inTx      bool // whether the workers wrap the writes in transactions
+}
+
+// newWorkloadRun returns the workload of -workload against the scenarios of
+// the suite name over data, or false when the suite lacks one of them.  rmw
+// reads with a select scenario of its own, set up on the writer pool, so that
+// under -splitPools its select shares the connection and transaction of its
+// update
+func newWorkloadRun(name string, data []model.User) (*workloadRun, bool) {
+	w := &workloadRun{scenarios: map[string]Scenario{}, keys: &keySpace{recs: append([]model.User(nil), keyOrder(workloadKeyDist(), data)...)}}
+	for _, phase := range opt.workload.phases() {
+		s, ok := lookupScenario(name, phase)
+		if !ok {
+			log.Printf("Skipping the workload for %s, which has no %s phase", name, phase)
+			return nil, false
+		}
+		w.scenarios[phase] = s
+	}
+	if opt.workload.has(opReadModifyWrite) {
+		w.scenarios[opReadModifyWrite] = cloneScenario(w.scenarios[phaseSelect])
+	}
+	w.inTx = useTx()
+	for _, s := range w.scenarios {
+		w.inTx = w.inTx && !managesTx(s)
+	}
+	return w, true
+}
+
+// setup sets up every scenario of w on the pool of its phase.  teardown must
+// be called even when setup fails
+func (w *workloadRun) setup() error {
+	for key, s := range w.scenarios {
+		if err := s.Setup(poolFor(key)); err != nil {
+			return fmt.Errorf("%s %s setup: %w", s.Name(), key, err)
+		}
+		w.ready = append(w.ready, key)
+	}
+	return nil
+}
+
+// teardown tears down the scenarios that setup set up
+func (w *workloadRun) teardown() error {
+	var errs []error
+	for _, key := range w.ready {
+		s := w.scenarios[key]
+		if err := s.Teardown(); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s teardown: %w", s.Name(), key, err))
+		}
+	}
+	w.ready = nil
+	return errors.Join(errs...)
+}
+
+// workloadPhase returns the Result phase of op, e.g. "workload/select"
+func workloadPhase(op string) string {
+	return "workload/" + op
+}
+
+// runWorkload loads data and runs -workload against the scenarios of the
+// suite name for -workloadOps operations or for -workloadDuration, whichever
+// ends first, on -workers goroutines.  It returns a Result per operation
+// followed by the Result of the whole workload, all over the same wall time.
+// Suites lacking a scenario for one of the operations are skipped
+func runWorkload(name string, data []model.User) (results []Result, err error) {
+	w, ok := newWorkloadRun(name, data)
+	if !ok {
+		return nil, nil
+	}
+	if len(data) == 0 {
+		return nil, errors.New("the workload needs a -rowCount of at least 1")
+	}
+	if err = loadData(data); err != nil {
+		return
+	}
+
+	log.Printf("Executing %s workload %s with %s keys", name, opt.workload.String(), workloadKeyDist())
+	defer func() {
+		if err2 := w.teardown(); err2 != nil && err == nil {
+			err = err2
+		}
+	}()
+	if err = w.setup(); err != nil {
+		return nil, err
+	}
+
+	total := *opt.workloadOps
+	if total == 0 && *opt.workloadDuration == 0 {
+		total = len(data)
+	}
+	var deadline time.Time
+	bar := progressbar.Default(-1)
+	if total > 0 {
+		bar = progressbar.Default(int64(total))
+	}
+
+	poolBefore := opt.db.Stats()
+	start := time.Now()
+	if *opt.workloadDuration > 0 {
+		deadline = start.Add(*opt.workloadDuration)
+	}
+	workers := *opt.workers
+	parts := make([]map[string]*Result, workers)
+	txs := make([]Result, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		parts[i] = map[string]*Result{}
+		for _, e := range opt.workload {
+			parts[i][e.op] = &Result{Latency: NewHistogram()}
+		}
+		txs[i].Latency = NewHistogram()
+		ops := -1
+		if total > 0 {
+			ops = (i+1)*total/workers - i*total/workers
+		}
+		wg.Add(1)
+		go func(i, ops int) {
+			defer wg.Done()
+			d := &workloadDraws{rng: rand.New(rand.NewSource(workloadSeed + int64(i))),
+				keys: newKeyChooser(workloadKeyDist(), i*len(data)/workers)}
+			errs[i] = w.worker(ops, deadline, d, parts[i], &txs[i], bar)
+		}(i, ops)
+	}
+	wg.Wait()
+	bar.Finish()
+	end := time.Now()
+	poolAfter := opt.db.Stats()
+
+	config := currentRunConfig()
+	config.KeyDist = workloadKeyDist()
+	all := Result{Scenario: name, Phase: "workload", Start: start, End: end, Latency: NewHistogram(), Config: config}
+	all.PoolWaits = poolAfter.WaitCount - poolBefore.WaitCount
+	all.PoolWaitTime = poolAfter.WaitDuration - poolBefore.WaitDuration
+	for _, e := range opt.workload {
+		res := Result{Scenario: name, Phase: workloadPhase(e.op), Start: start, End: end, Latency: NewHistogram(),
+			Config: config}
+		for i := range parts {
+			res.add(*parts[i][e.op])
+		}
+		all.add(res)
+		results = append(results, res)
+	}
+	for _, tx := range txs {
+		all.add(tx)
+	}
+	log.Printf("%s workload: %d operations in %s, %d rows inserted, %d misses", name, all.Ops,
+		roundLatency(all.Duration()), w.inserted.Load(), all.Misses)
+	return append(results, all), errors.Join(errs...)
+}
+
+// worker runs ops operations of the workload, or operations until deadline
+// when ops is negative, recording each in parts by operation and the commits
+// in tx.  With -splitPools the selects run on the read-only pool, except for
+// the select of a read-modify-write
+func (w *workloadRun) worker(ops int, deadline time.Time, d *workloadDraws, parts map[string]*Result, tx *Result,
+	bar *progressbar.ProgressBar) (err error) {
+	ex := &executor{db: opt.db}
+	readEx := ex
+	if opt.readDB != nil {
+		readEx = &executor{db: opt.readDB}
+	}
+	defer func() {
+		if ex.tx != nil {
+			ex.tx.Rollback()
+		}
+	}()
+
+	uncommitted := 0
+	for i := 0; ops < 0 || i < ops; i++ {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			break
+		}
+		op := opt.workload.pick(d.rng)
+		e := ex
+		if op == phaseSelect {
+			e = readEx
+		} else if w.inTx && ex.tx == nil {
+			if ex.tx, err = ex.db.Begin(); err != nil {
+				return
+			}
+		}
+
+		res := parts[op]
+		e.stages = res.Stages
+		opStart := time.Now()
+		e.begin()
+		n, statements, runErr := w.run(e, op, d)
+		e.end()
+		res.Stages = e.stages
+		res.Latency.Record(time.Since(opStart))
+		res.Ops++
+		res.Statements += statements
+		res.RowsAffected += n
+		switch {
+		case isNoRows(runErr):
+			res.Misses++
+		case runErr != nil:
+			res.countError(runErr)
+			log.Printf("[warning] workload %s: %v", op, runErr)
+		}
+		bar.Add(1)
+
+		if op != phaseSelect {
+			uncommitted++
+		}
+		if w.inTx && *opt.commitEvery > 0 && uncommitted >= *opt.commitEvery {
+			w.commit(ex, tx)
+			uncommitted = 0
+		}
+	}
+	if ex.tx != nil {
+		w.commit(ex, tx)
+	}
+	return nil
+}
+
+// run performs one op on a row drawn by d from the key space and returns the rows
+// it affected and the number of statements it executed
+func (w *workloadRun) run(ex *executor, op string, d *workloadDraws) (n, statements int64, err error) {
+	switch op {
+	case phaseSelect:
+		_, rec := d.choose(w.keys)
+		n, err = w.scenarios[phaseSelect].Run(ex, rec)
+		return n, 1, err
+	case phaseInsert:
+		_, rec := d.choose(w.keys)
+		rec.User = fmt.Sprintf("%s.%d", rec.User, w.inserted.Add(1))
+		if n, err = w.scenarios[phaseInsert].Run(ex, rec); err == nil {
+			w.keys.add(rec)
+		}
+		return n, 1, err
+	case phaseUpdate:
+		i, rec := d.choose(w.keys)
+		if n, err = w.scenarios[phaseUpdate].Run(ex, rec); err == nil {
+			w.keys.put(i, rec)
+		}
+		return n, 1, err
+	default:
+		i, rec := d.choose(w.keys)
+		if _, err = w.scenarios[opReadModifyWrite].Run(ex, rec); err != nil {
+			return 0, 1, err
+		}
+		if n, err = w.scenarios[phaseUpdate].Run(ex, rec); err == nil {
+			w.keys.put(i, rec)
+		}
+		return n, 2, err
+	}
+}
+
+// commit commits the worker's transaction, recording it in res, and lets
+// every scenario release what it bound to the transaction
+func (w *workloadRun) commit(ex *executor, res *Result) {
+	tx := ex.tx
+	if err := ex.commit(nil, res); err != nil {
+		res.countError(err)
+		log.Printf("[warning] workload commit: %v", err)
+	}
+	for _, s := range w.scenarios {
+		if r, ok := s.(TxReleaser); ok {
+			r.ReleaseTx(tx)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/lbe/go-sql-test/gen/model"
+	"github.com/lbe/go-sql-test/models"
+)
+
+// openSplitPools points opt.db at a single-connection writer pool and
+// opt.readDB at a read-only pool of a new file database holding the benchmark
+// schema, as -splitPools does, for the duration of t
+func openSplitPools(t *testing.T) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open(driverName, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	readDB, err := sql.Open(driverName, "file:"+path+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedDB, savedReadDB := opt.db, opt.readDB
+	opt.db, opt.readDB = db, readDB
+	t.Cleanup(func() {
+		opt.db, opt.readDB = savedDB, savedReadDB
+		readDB.Close()
+		db.Close()
+	})
+	if err := dbCreateSchema(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWorkloadReadModifyWriteSplitPools(t *testing.T) {
+	for _, layer := range parityLayers {
+		for _, inTx := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/tx=%t", layer, inTx), func(t *testing.T) {
+				openSplitPools(t)
+				setKeyOptions(t, keysSequential, orderSorted, 0.99)
+				saved, savedTx, savedEvery := opt.workload, opt.useTransaction, opt.commitEvery
+				commitEvery := 0
+				opt.workload, opt.useTransaction, opt.commitEvery = workloadMix{{opReadModifyWrite, 1}}, &inTx, &commitEvery
+				defer func() { opt.workload, opt.useTransaction, opt.commitEvery = saved, savedTx, savedEvery }()
+
+				data := make([]model.User, 20)
+				for i := range data {
+					data[i] = parityUser(fmt.Sprintf("user%02d", i))
+					if _, err := opt.db.Exec(models.SqlUpsertUsers(1), upsertArgs(data[i])...); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				w, ok := newWorkloadRun(layer, data)
+				if !ok {
+					t.Fatalf("no workload for %s", layer)
+				}
+				defer func() {
+					if err := w.teardown(); err != nil {
+						t.Error(err)
+					}
+				}()
+				if err := w.setup(); err != nil {
+					t.Fatal(err)
+				}
+
+				ex := &executor{db: opt.db}
+				if inTx {
+					var err error
+					if ex.tx, err = ex.db.Begin(); err != nil {
+						t.Fatal(err)
+					}
+				}
+				d := &workloadDraws{rng: rand.New(rand.NewSource(workloadSeed)), keys: newKeyChooser(keysSequential, 0)}
+				for range data {
+					if n, _, err := w.run(ex, opReadModifyWrite, d); err != nil || n != 1 {
+						t.Fatalf("rmw = %d, %v, want 1 row updated", n, err)
+					}
+				}
+				if inTx {
+					var res Result
+					w.commit(ex, &res)
+					if res.Errors != 0 {
+						t.Fatalf("commit failed")
+					}
+				}
+
+				var years int
+				if err := opt.db.QueryRow("SELECT count(*) FROM user WHERE year_birth = 1969").Scan(&years); err != nil {
+					t.Fatal(err)
+				}
+				if years != len(data) {
+					t.Errorf("%d rows updated, want %d", years, len(data))
+				}
+			})
+		}
+	}
+}
+
+func TestWorkloadMixSet(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string // String of the mix, empty for an error
+	}{
+		{"a", "select=50,update=50"},
+		{"F", "select=50,rmw=50"},
+		{"select=90, insert=10", "select=90,insert=10"},
+		{"select=1,update=0", "select=1"},
+		{"select=50,select=50", ""},
+		{"select=50,update=0,update=5", ""},
+		{"scan=1", ""},
+		{"select", ""},
+		{"select=-1", ""},
+		{"update=0", ""},
+		{"e", ""},
+	}
+	for _, tt := range tests {
+		var m workloadMix
+		err := m.Set(tt.value)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("Set(%q) = %s, want an error", tt.value, m.String())
+			}
+		} else if err != nil || m.String() != tt.want {
+			t.Errorf("Set(%q) = %s, %v, want %s", tt.value, m.String(), err, tt.want)
+		}
+	}
+}